@@ -2,22 +2,36 @@ package cmd
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/nwidger/jsoncolor"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/cube2222/jql/jql"
 	"github.com/cube2222/jql/jql/app"
 )
 
 var (
-	cfgFile    string
-	monochrome bool
+	cfgFile      string
+	monochrome   bool
+	exactNumbers bool
+	fromFile     string
+	libPath      []string
+	timeout      time.Duration
+	limits       jql.Limits
+	slurp        bool
+	reduce       bool
+	reduceInit   string
 )
 
 type encoder interface {
@@ -33,6 +47,9 @@ var rootCmd = &cobra.Command{
 	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		input := json.NewDecoder(bufio.NewReaderSize(os.Stdin, 4096*16))
+		if exactNumbers {
+			input.UseNumber()
+		}
 		w := bufio.NewWriterSize(os.Stdout, 4096*16)
 		defer w.Flush()
 		var output encoder
@@ -43,12 +60,46 @@ var rootCmd = &cobra.Command{
 		}
 		output.SetIndent("", "  ")
 
-		if len(args) == 0 {
-			args = append(args, "(id)")
+		query := "(id)"
+		switch {
+		case fromFile != "" && len(args) > 0:
+			log.Fatal("can't use both a query argument and --from-file")
+		case fromFile != "":
+			data, err := ioutil.ReadFile(fromFile)
+			if err != nil {
+				log.Fatalf("couldn't read query file: %s", err)
+			}
+			query = string(data)
+		case len(args) > 0:
+			query = args[0]
+		}
+		searchPath := append(libPath, filepath.SplitList(os.Getenv("JQL_PATH"))...)
+		searchPath = append(searchPath, ".")
+		opts := []app.Option{app.WithLibPath(searchPath...), app.WithLimits(limits)}
+		switch {
+		case slurp && reduce:
+			log.Fatal("can't use both --slurp and --reduce")
+		case slurp:
+			opts = append(opts, app.WithSlurp())
+		case reduce:
+			opts = append(opts, app.WithReduce(reduceInit))
+		}
+		app := app.NewApp(query, input, output, opts...)
+
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
 		}
-		app := app.NewApp(args[0], input, output)
 
-		if err := app.Run(); err != nil {
+		if err := app.RunContext(ctx); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				log.Fatalf("query timed out after %s", timeout)
+			}
+			if fromFile != "" {
+				log.Fatalf("%s: %s", fromFile, err)
+			}
 			log.Fatal(err)
 		}
 	},
@@ -72,6 +123,17 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.jql.yaml)")
 	rootCmd.PersistentFlags().BoolVar(&monochrome, "monochrome", false, "monochrome (don't colorize output)")
+	rootCmd.Flags().StringVarP(&fromFile, "from-file", "f", "", "read the query from a file")
+	rootCmd.Flags().StringSliceVar(&libPath, "lib-path", nil, "directories to look for imported libraries in, before the ones in $JQL_PATH and the current directory")
+	rootCmd.Flags().BoolVarP(&slurp, "slurp", "s", false, "collect all input documents into an array and run the query once against it")
+	rootCmd.Flags().BoolVar(&reduce, "reduce", false, "fold all input documents into a single value, the query gets each document with the accumulator as $acc and returns the new accumulator")
+	rootCmd.Flags().StringVar(&reduceInit, "init", "null", "query returning the initial accumulator for --reduce")
+	rootCmd.Flags().DurationVar(&timeout, "timeout", 0, "abort if processing takes longer than this, e.g. 5s (0 means no limit)")
+	rootCmd.Flags().IntVar(&limits.MaxElements, "max-elements", 0, fmt.Sprintf("maximum total number of elements in the arrays and objects created by the query for each document (0 means the built-in limit of %d)", jql.ElementsHardLimit))
+	rootCmd.Flags().IntVar(&limits.MaxDepth, "max-depth", 0, fmt.Sprintf("maximum depth of nested calls to functions defined in the query (0 means the built-in limit of %d)", jql.DepthHardLimit))
+	rootCmd.Flags().IntVar(&limits.MaxStringLength, "max-string-length", 0, fmt.Sprintf("maximum length of a string built by the query (0 means the built-in limit of %d)", jql.StringLengthHardLimit))
+	rootCmd.Flags().IntVar(&limits.MaxSteps, "max-steps", 0, "maximum number of evaluation steps per document (0 means no limit)")
+	rootCmd.PersistentFlags().BoolVar(&exactNumbers, "exact-numbers", true, "keep numbers exact instead of decoding them as 64-bit floats")
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -1,12 +1,14 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 
 	"github.com/cube2222/jql/jql"
-	"github.com/cube2222/jql/jql/functions"
 	"github.com/cube2222/jql/jql/parser"
+	"github.com/cube2222/jql/jql/query"
 )
 
 type Input interface {
@@ -18,51 +20,174 @@ type Output interface {
 }
 
 type App struct {
-	query  string
-	input  Input
-	output Output
+	query   string
+	input   Input
+	output  Output
+	libPath []string
+	limits  jql.Limits
+	slurp   bool
+	reduce  bool
+	init    string
 }
 
-func NewApp(query string, input Input, output Output) *App {
-	return &App{
-		query:  query,
-		input:  input,
-		output: output,
+type Option func(app *App)
+
+// WithLibPath sets the directories imported libraries are looked up in.
+func WithLibPath(dirs ...string) Option {
+	return func(app *App) {
+		app.libPath = dirs
+	}
+}
+
+// WithLimits sets the limits each document's evaluation is subject to.
+func WithLimits(limits jql.Limits) Option {
+	return func(app *App) {
+		app.limits = limits
+	}
+}
+
+// WithSlurp makes the app collect all input documents into a single array and evaluate the query once against it.
+func WithSlurp() Option {
+	return func(app *App) {
+		app.slurp = true
+	}
+}
+
+// WithReduce makes the app fold the input documents into a single value, which is written out at the end.
+// The init query is evaluated once to get the initial accumulator.
+// Then the query is evaluated for each document, with the accumulator available as $acc, and its result becomes the new accumulator.
+func WithReduce(init string) Option {
+	return func(app *App) {
+		app.reduce = true
+		app.init = init
+	}
+}
+
+func NewApp(query string, input Input, output Output, opts ...Option) *App {
+	app := &App{
+		query:   query,
+		input:   input,
+		output:  output,
+		libPath: []string{"."},
+	}
+	for _, opt := range opts {
+		opt(app)
 	}
+	return app
 }
 
 func (app *App) Run() error {
-	parsed := parser.Parse(app.query)
-	expr, err := parsed.GetExecutionExpression(parser.ExpressionConstructorContext{
-		Functions: functions.Functions,
-		ConstantExpression: func(value interface{}) jql.Expression {
-			return jql.NewConstant(value)
-		},
-	})
+	return app.RunContext(context.Background())
+}
+
+// RunContext is like Run, but stops processing documents once the context is done.
+func (app *App) RunContext(ctx context.Context) error {
+	if app.slurp && app.reduce {
+		return fmt.Errorf("can't both slurp and reduce the input")
+	}
+
+	opts := query.Options{LibPath: app.libPath, Limits: app.limits}
+	if app.reduce {
+		opts.Variables = map[string]interface{}{"acc": nil}
+	}
+	compiled, err := compile(app.query, opts)
 	if err != nil {
-		return fmt.Errorf("couldn't get execution expression from AST: %w", err)
+		return err
 	}
 
+	switch {
+	case app.slurp:
+		documents := []interface{}{}
+		err := app.forEachDocument(ctx, func(document interface{}) error {
+			documents = append(documents, document)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return app.evalAndEncode(ctx, compiled, documents)
+
+	case app.reduce:
+		initQuery, err := compile(app.init, query.Options{LibPath: app.libPath, Limits: app.limits})
+		if err != nil {
+			return fmt.Errorf("invalid initial accumulator query: %w", err)
+		}
+		acc, err := initQuery.Eval(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("couldn't get initial accumulator value: %w", err)
+		}
+
+		err = app.forEachDocument(ctx, func(document interface{}) error {
+			acc, err = compiled.EvalWithVariables(ctx, document, map[string]interface{}{"acc": acc})
+			if err != nil {
+				return fmt.Errorf("couldn't get expression value for object: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = app.output.Encode(acc)
+		if err != nil {
+			return fmt.Errorf("couldn't encode json: %w", err)
+		}
+		return nil
+
+	default:
+		return app.forEachDocument(ctx, func(document interface{}) error {
+			return app.evalAndEncode(ctx, compiled, document)
+		})
+	}
+}
+
+func compile(text string, opts query.Options) (*query.Query, error) {
+	compiled, err := query.Compile(text, opts)
+	if err != nil {
+		var parseErr *parser.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, fmt.Errorf("%w\n%s", err, parseErr.Snippet())
+		}
+		var validationErr *parser.ValidationError
+		if errors.As(err, &validationErr) {
+			return nil, fmt.Errorf("invalid query:\n%s", validationErr.Snippets())
+		}
+		return nil, err
+	}
+	return compiled, nil
+}
+
+// forEachDocument decodes the input documents one by one, stopping early if the context is done.
+func (app *App) forEachDocument(ctx context.Context, f func(document interface{}) error) error {
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		var inObject interface{}
 		err := app.input.Decode(&inObject)
 		if err != nil {
 			if err == io.EOF {
-				break
+				return nil
 			}
 			return fmt.Errorf("couldn't decode json: %w", err)
 		}
 
-		outObject, err := expr.Get(inObject)
-		if err != nil {
-			return fmt.Errorf("couldn't get expression value for object: %w", err)
+		if err := f(inObject); err != nil {
+			return err
 		}
+	}
+}
 
-		err = app.output.Encode(outObject)
-		if err != nil {
-			return fmt.Errorf("couldn't encode json: %w", err)
-		}
+func (app *App) evalAndEncode(ctx context.Context, compiled *query.Query, inObject interface{}) error {
+	outObject, err := compiled.Eval(ctx, inObject)
+	if err != nil {
+		return fmt.Errorf("couldn't get expression value for object: %w", err)
 	}
 
+	err = app.output.Encode(outObject)
+	if err != nil {
+		return fmt.Errorf("couldn't encode json: %w", err)
+	}
 	return nil
 }
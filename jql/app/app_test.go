@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -273,6 +275,65 @@ func TestApp_Run(t *testing.T) {
 			query:  `(gt 5 4)`,
 			output: `true`,
 		},
+		{
+			query:  `(eq ("count") 3)`,
+			output: `true`,
+		},
+		{
+			query:  `(eq (array 1 (object "a" 2)) (array 1.0 (object "a" 2e0)))`,
+			output: `true`,
+		},
+		{
+			query:  `(pipe (array ("count") 10 20 30 40) ((0)))`,
+			output: `30`,
+		},
+		{
+			query:  `("countries" ((range 1.0 3e0) ("name")))`,
+			output: `["United States", "Germany"]`,
+		},
+		{
+			query:  `(sprintf "%d %.1f" 3 2.5)`,
+			output: `"3 2.5"`,
+		},
+		{
+			query:  `(sprintf "%x %d" 1.5 1.5)`,
+			output: `"0x1.8p+00 %!d(float64=1.5)"`,
+		},
+		{
+			query:  `(gt 9.99 9.5)`,
+			output: `true`,
+		},
+		{
+			query:  `(lt -5 -4.5e0)`,
+			output: `true`,
+		},
+		{
+			query:  `(array -0 1.5E3 2e-2 -12)`,
+			output: `[0, 1500, 0.02, -12]`,
+		},
+		{
+			query: `("countries" (filter (lt ("population") 3.9e7)))`,
+			output: `[
+  {
+    "eu_since": "2004",
+    "european": true,
+    "name": "Poland",
+    "population": 38000000
+  }
+]`,
+		},
+		{
+			query:  `(array "a\"b" "tab\there" "caf\u00e9" "\ud83d\ude00" "a\/b")`,
+			output: `["a\"b", "tab\there", "café", "😀", "a/b"]`,
+		},
+		{
+			query:  "(array `^\\d+ \"quoted\"$`)",
+			output: `["^\\d+ \"quoted\"$"]`,
+		},
+		{
+			query:  `(object "with \"quotes\"" ("count"))`,
+			output: `{"with \"quotes\"": 3}`,
+		},
 		{
 			query:  `(and true true true)`,
 			output: `true`,
@@ -372,6 +433,221 @@ func TestApp_Run(t *testing.T) {
   "United States"
 ]`,
 		},
+		{
+			query: `; names of european countries
+                            ("countries"
+                              (pipe
+                                (filter ("european")) ; only the european ones
+                                ((keys) ("name"))))`,
+			output: `[
+  "Poland",
+  "Germany"
+]`,
+		},
+		{
+			query: `(let (total ("count"))
+                              ("countries" ((keys) (array ("name") $total))))`,
+			output: `[
+  ["Poland", 3],
+  ["United States", 3],
+  ["Germany", 3]
+]`,
+		},
+		{
+			query: `(let (threshold 50000000)
+                              (min (gt $threshold 40000000))
+                              ("countries" (pipe
+                                (filter (and $min (gt ("population") $threshold)))
+                                ((keys) ("name")))))`,
+			output: `["United States", "Germany"]`,
+		},
+		{
+			query:  `(let (x 1) (let (x 2) (array $x)))`,
+			output: `[2]`,
+		},
+		{
+			query: `(defn big (min) (gt ("population") $min))
+                            (defn label (name population) (sprintf "%s (%.0f)" $name $population))
+                            ("countries" (pipe
+                              (filter (big 50000000))
+                              ((keys) (label ("name") ("population")))))`,
+			output: `["United States (327000000)", "Germany (83000000)"]`,
+		},
+		{
+			query:  `("countries" (pipe (filter (fn (country) (pipe $country ("european")))) ((keys) ("name"))))`,
+			output: `["Poland", "Germany"]`,
+		},
+		{
+			query: `(defn twice (f x) (call $f (call $f $x)))
+                            (let (suffix "!") (twice (fn (s) (sprintf "%s%s" $s $suffix)) "hey"))`,
+			output: `"hey!!"`,
+		},
+		{
+			query: `(defn names (country) (pipe $country ("name")))
+                            ("countries" (pipe (filter (fn (c) (pipe $c ("eu_since")))) ((keys) (call names (id)))))`,
+			output: `["Poland", "Germany"]`,
+		},
+		{
+			query: `(defn flatten (x) (ifte (pipe $x ("child")) (flatten (pipe $x ("child"))) $x))
+                            (flatten (object "child" (object "child" (object "value" 3))))`,
+			output: `{"value": 3}`,
+		},
+		{
+			query:  `("countries" ((keys) (div ("population") 1000000)))`,
+			output: `[38, 327, 83]`,
+		},
+		{
+			query:  `(array (add 1 2 3) (sub 10 1 2) (sub 5) (mul 2 2.5) (div 7 2) (mod -7 3) (mod 7.5 2))`,
+			output: `[6, 7, -5, 5, 3.5, -1, 1.5]`,
+		},
+		{
+			query:  `(array (neg 3) (abs -2.5) (min 3 1 2) (max 3 1.5 2) (pow 2 10) (pow 4 0.5) (sqrt 9))`,
+			output: `[-3, 2.5, 1, 3, 1024, 2, 3]`,
+		},
+		{
+			query:  `(array (floor 2.7) (floor -2.5) (ceil 2.1) (round 2.5) (round -2.5) (round 3))`,
+			output: `[2, -3, 3, 3, -3, 3]`,
+		},
+		{
+			query:  `(array (floor 1e300) (ceil -1e300) (round 4503599627370495.5))`,
+			output: `[1e300, -1e300, 4503599627370496]`,
+		},
+		{
+			query:  `("countries" (pipe (sortby (desc ("european")) ("name")) ((keys) ("name"))))`,
+			output: `["Germany", "Poland", "United States"]`,
+		},
+		{
+			query:  `(pipe (array 3 "a" null (array 1 2) (array 1) true 1.5 (object "b" 1) (object "a" 2) false 3) (sort))`,
+			output: `[null, false, true, 1.5, 3, 3, "a", [1], [1, 2], {"a": 2}, {"b": 1}]`,
+		},
+		{
+			query:  `(pipe (array (object "k" 1 "v" "a") (object "k" 0 "v" "b") (object "k" 1 "v" "c") (object "k" 0 "v" "d")) (sortby ("k")) ((keys) ("v")))`,
+			output: `["b", "d", "a", "c"]`,
+		},
+		{
+			query:  `(array (lt null false) (lt true 0) (gt "a" 5) (lt (array 1 2) (array 1 3)) (gt (object "a" 1) (array)))`,
+			output: `[true, true, true, true, true]`,
+		},
+		{
+			query:  `("countries" (groupby ("european") ((keys) ("name"))))`,
+			output: `{"false": ["United States"], "true": ["Poland", "Germany"]}`,
+		},
+		{
+			query:  `("countries" (groupby ("european") (sum (id) ("population"))))`,
+			output: `{"false": 327000000, "true": 121000000}`,
+		},
+		{
+			query:  `("countries" (groupentries ("eu_since") (count (id))))`,
+			output: `[{"key": null, "values": 1}, {"key": "1993", "values": 1}, {"key": "2004", "values": 1}]`,
+		},
+		{
+			query:  `(pipe (array 1 "1" (array 2) 3) (groupby (id)))`,
+			output: `{"1": [1, "1"], "3": [3], "[2]": [[2]]}`,
+		},
+		{
+			query:  `("countries" (filter ("eu_since") ("name")))`,
+			output: `["Poland", "Germany"]`,
+		},
+		{
+			query:  `("countries" (filter (fn (c) (pipe $c ("european"))) (fn (c) (pipe $c ("population")))))`,
+			output: `[38000000, 83000000]`,
+		},
+		{
+			query:  `(array ("countries" (map ("name"))) (pipe (array) (map (id))) (pipe (object "a" 1 "b" 2) (map (add (id) 1))))`,
+			output: `[["Poland", "United States", "Germany"], [], {"a": 2, "b": 3}]`,
+		},
+		{
+			query:  `("countries" (map (array (upper ("name")) (lower ("name")) (length ("name")) (substr ("name") 0 3) (indexof ("name") "a"))))`,
+			output: `[["POLAND", "poland", 6, "Pol", 3], ["UNITED STATES", "united states", 13, "Uni", 9], ["GERMANY", "germany", 7, "Ger", 4]]`,
+		},
+		{
+			query:  `(array (upper "zażółć") (length "zażółć") (substr "zażółć" 2 3) (substr "zażółć" -2) (substr "abc" 5) (indexof "zażółć" "ół") (indexof "abc" "x"))`,
+			output: `["ZAŻÓŁĆ", 6, "żół", "łć", "", 3, -1]`,
+		},
+		{
+			query:  `(array (trim "  x ") (ltrim "  x ") (rtrim "  x ") (trim "xxhixx" "x") (split "a,b,,c" ",") (split "  a b  ") (replace "a-b-c" "-" "+"))`,
+			output: `["x", "x ", "  x", "hi", ["a", "b", "", "c"], ["a", "b"], "a+b+c"]`,
+		},
+		{
+			query:  `(array (contains "hello" "ell") (startswith "hello" "he") (endswith "hello" "he") (repeat "ab" 3) (padleft "7" 3 "0") (padright "ab" 5 "xy") (padleft "żółw" 6) (length (array 1 2)))`,
+			output: `[true, true, false, "ababab", "007", "abxyx", "  żółw", 2]`,
+		},
+		{
+			query:  `("countries" (filter (test "^(Pol|Ger)" ("name")) ("name")))`,
+			output: `["Poland", "Germany"]`,
+		},
+		{
+			query:  `(array (match "(?P<user>\\w+)@(\\w+)(\\.org)?" "mail joe@example.com") (match "x" "abc"))`,
+			output: `[{"match": "joe@example", "groups": ["joe", "example", null], "named": {"user": "joe"}}, null]`,
+		},
+		{
+			query:  `(array (scan "\\d+" "a1b22c333") (scan "(\\w)=(\\d)" "a=1 b=2") (regsub "(\\w+)@(\\w+)" "joe@x and ann@y" "${2}:$1"))`,
+			output: `[["1", "22", "333"], [["a", "1"], ["b", "2"]], "x:joe and y:ann"]`,
+		},
+		{
+			query:  `("countries" (map (test (sprintf "^%sol" (substr ("name") 0 1)) ("name"))))`,
+			output: `[true, false, false]`,
+		},
+		{
+			query:  `("countries" (map (array (type ("eu_since")) (tonumber ("eu_since") null) (add (tonumber ("eu_since") 0) 1))))`,
+			output: `[["string", 2004, 2005], ["null", null, 1], ["string", 1993, 1994]]`,
+		},
+		{
+			query:  `(array (type 1) (type true) (type (object)) (isnumber 1) (isstring 1) (isnull null) (isarray (array)) (isobject (array)) (isboolean false))`,
+			output: `["number", "boolean", "object", true, false, true, true, false, true]`,
+		},
+		{
+			query:  `(array (tostring "a") (tostring 1.5) (tostring (array 1 "x")) (tobool "true") (tobool "0") (tobool 0) (tobool null) (tonumber " 42 "))`,
+			output: `["a", "1.5", "[1,\"x\"]", true, false, true, false, 42]`,
+		},
+		{
+			query:  `(fromjson (tojson (object "name" ("countries" (0 ("name"))) "tags" (array 1 null))))`,
+			output: `{"name": "Poland", "tags": [1, null]}`,
+		},
+		{
+			query:  `("countries" (map (pipe (rename "name" "country") (set "size" (ifte (gt ("population") 50000000) "big" "small")) (omit "population" "european"))))`,
+			output: `[{"country": "Poland", "eu_since": "2004", "size": "small"}, {"country": "United States", "size": "big"}, {"country": "Germany", "eu_since": "1993", "size": "big"}]`,
+		},
+		{
+			query:  `("countries" (map (array (has "eu_since") (pick "name" "missing"))))`,
+			output: `[[true, {"name": "Poland"}], [false, {"name": "United States"}], [true, {"name": "Germany"}]]`,
+		},
+		{
+			query:  `(array (merge (object "a" (object "x" 1) "b" 1) (object "a" (object "y" 2))) (deepmerge (object "a" (object "x" 1) "b" 1) (object "a" (object "y" 2))) (pipe (object "a" 1 "b" 2) (rename "a" "b" "b" "a")))`,
+			output: `[{"a": {"y": 2}, "b": 1}, {"a": {"x": 1, "y": 2}, "b": 1}, {"a": 2, "b": 1}]`,
+		},
+		{
+			query:  `(array (getpath (array "countries" 1 "name")) (getpath (array "countries" 5 "name")) (getpath (array "missing" "deeper")))`,
+			output: `["United States", null, null]`,
+		},
+		{
+			query:  `(pipe (setpath (array "countries" 0 "tags" 1) "big") (delpath (array "countries" 2)) (delpath (array "countries" 1 "eu_since")) ("countries" (map (pick "name" "tags" "eu_since"))))`,
+			output: `[{"name": "Poland", "eu_since": "2004", "tags": [null, "big"]}, {"name": "United States"}]`,
+		},
+		{
+			query:  `(pipe (object "a" (array 1 (object "b" "x")) "c" (array)) (array (paths) (paths (isstring (id))) (walk (ifte (isnumber (id)) (add (id) 1) (id)))))`,
+			output: `[[["a", 0], ["a", 1, "b"], ["c"]], [["a", 1, "b"]], {"a": [2, {"b": "x"}], "c": []}]`,
+		},
+		{
+			query:  `(defn falsey (x) (not $x)) (let (nullish null) (truehood true) (array (falsey $nullish) $truehood))`,
+			output: `[true, true]`,
+		},
+		{
+			query:  `(sum (elem "countries") (elem "population"))`,
+			output: `448000000`,
+		},
+		{
+			query:  `(array (count ("countries")) (count ("countries") ("european")) (avg ("countries") ("population")) (avg (array)))`,
+			output: `[3, 2, 149333333.33333334, null]`,
+		},
+		{
+			query:  `(array (min ("countries") ("population")) (max ("countries") (fn (c) (pipe $c ("population")))) (max (array)))`,
+			output: `[38000000, 327000000, null]`,
+		},
+		{
+			query:  `(array (median (array 3 1 2)) (median (array 4 1 3 2)) (percentile (array 1 2 3 4) 90) (percentile ("countries") 0 ("population")))`,
+			output: `[2, 2.5, 3.7, 38000000]`,
+		},
 		{
 			query: `("countries" ((keys) (recover (ifte ("european") (id) (error "not european")))))`,
 			output: `[
@@ -408,3 +684,330 @@ func TestApp_Run(t *testing.T) {
 		})
 	}
 }
+
+func TestApp_RunExactNumbers(t *testing.T) {
+	input := `{"id": 12345678901234567891, "ts": 1577462489123456789, "price": 0.1}`
+
+	tests := []struct {
+		query  string
+		output string
+	}{
+		{
+			query:  `(id)`,
+			output: `{"id":12345678901234567891,"price":0.1,"ts":1577462489123456789}`,
+		},
+		{
+			query:  `(eq ("id") 12345678901234567891)`,
+			output: `true`,
+		},
+		{
+			query:  `(eq ("id") 12345678901234567890)`,
+			output: `false`,
+		},
+		{
+			query:  `(array (lt ("ts") 1577462489123456790) (gt ("ts") 1577462489123456789.5))`,
+			output: `[true,false]`,
+		},
+		{
+			query:  `(eq ("price") 0.1)`,
+			output: `true`,
+		},
+		{
+			query:  `(sprintf "%d %x %.3f %v" ("id") ("ts") ("price") ("id"))`,
+			output: `"12345678901234567891 15e445c6aeb50715 0.100 12345678901234567891"`,
+		},
+		{
+			query:  `(array (add ("id") 1) (mul ("ts") 10) (div ("id") 3) (pow 2 64))`,
+			output: `[12345678901234567892,15774624891234567890,4115226300411522600,18446744073709551616]`,
+		},
+		{
+			query:  `(join (array ("id") ("ts")) ",")`,
+			output: `"12345678901234567891,1577462489123456789"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			input := json.NewDecoder(strings.NewReader(input))
+			input.UseNumber()
+			var buf bytes.Buffer
+			output := json.NewEncoder(&buf)
+			app := &App{
+				query:  tt.query,
+				input:  input,
+				output: output,
+			}
+			if err := app.Run(); err != nil {
+				t.Errorf("Run() error = %v", err)
+			}
+			assert.Equal(t, tt.output, strings.TrimSpace(buf.String()))
+		})
+	}
+}
+
+func TestApp_RunImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jql-lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	libraries := map[string]string{
+		"billing.jql": `; billing helpers
+                        (import "format.jql")
+                        (defn big (min) (gt ("population") $min))
+                        (defn label (name) (format/brackets $name))`,
+		"format.jql": `(defn brackets (s) (sprintf "[%s]" $s))`,
+		"cycle.jql":  `(import "cycle.jql") (defn f () 1)`,
+		"broken.jql": `(defn f (x) (x)))`,
+		"secret.txt": `password: hunter2`,
+		"invalid.jql": `(defn f (x)
+                          (nope $x))`,
+	}
+	for name, source := range libraries {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query  string
+		output string
+		errMsg string
+	}{
+		{
+			query:  `(import "billing.jql") ("countries" (pipe (filter (billing/big 50000000)) ((keys) (billing/label ("name")))))`,
+			output: `["[United States]", "[Germany]"]`,
+		},
+		{
+			query:  `(import "billing.jql" "b") (b/label ("countries" (0 ("name"))))`,
+			output: `"[Poland]"`,
+		},
+		{
+			query: `(import "billing.jql") (billing/format/brackets "x")`,
+			errMsg: "invalid query:\nline 1, column 24: no such function: billing/format/brackets\n" +
+				`(import "billing.jql") (billing/format/brackets "x")` + "\n" +
+				`                       ^`,
+		},
+		{
+			query:  `(import "missing.jql") (id)`,
+			errMsg: `couldn't get execution expression from AST: couldn't import library with index 0: couldn't load library missing.jql: library not found in search path: ` + dir,
+		},
+		{
+			query: `(import "broken.jql") (id)`,
+			errMsg: `couldn't get execution expression from AST: couldn't import library with index 0: couldn't parse library ` + filepath.Join(dir, "broken.jql") + `: syntax error at line 1, column 17: unexpected ')'` + "\n" +
+				"(defn f (x) (x)))\n" +
+				"                ^",
+		},
+		{
+			query: `(import "invalid.jql") (invalid/f 1)`,
+			errMsg: "invalid query:\nlibrary " + filepath.Join(dir, "invalid.jql") + ", line 2, column 27: no such function: nope, did you mean not?\n" +
+				"                          (nope $x))\n" +
+				"                          ^",
+		},
+		{
+			query:  `(import "secret.txt" "s") (id)`,
+			errMsg: `couldn't get execution expression from AST: couldn't import library with index 0: couldn't parse library ` + filepath.Join(dir, "secret.txt") + `: invalid token at line 1, column 9`,
+		},
+		{
+			query:  `(import "../secret.txt" "s") (id)`,
+			errMsg: `couldn't get execution expression from AST: couldn't import library with index 0: couldn't load library ../secret.txt: library path should be relative to the search path and can't leave it`,
+		},
+		{
+			query:  `(import "` + filepath.Join(dir, "format.jql") + `") (id)`,
+			errMsg: `couldn't get execution expression from AST: couldn't import library with index 0: couldn't load library ` + filepath.Join(dir, "format.jql") + `: library path should be relative to the search path and can't leave it`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			input := json.NewDecoder(strings.NewReader(testJson))
+			var buf bytes.Buffer
+			output := json.NewEncoder(&buf)
+			err := NewApp(tt.query, input, output, WithLibPath(dir)).Run()
+			if tt.errMsg != "" {
+				assert.EqualError(t, err, tt.errMsg)
+				return
+			}
+			if err != nil {
+				t.Errorf("Run() error = %v", err)
+			}
+			assert.JSONEq(t, tt.output, string(buf.Bytes()))
+		})
+	}
+
+	err = NewApp(`(import "cycle.jql") (cycle/f)`, json.NewDecoder(strings.NewReader(testJson)), json.NewEncoder(ioutil.Discard), WithLibPath(dir)).Run()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "import cycle")
+}
+
+func TestApp_RunStream(t *testing.T) {
+	input := `{"level": "info", "msg": "started"}
+{"level": "error", "msg": "failed"}
+{"level": "info", "msg": "retried"}`
+
+	tests := []struct {
+		name   string
+		query  string
+		opts   []Option
+		output string
+	}{
+		{
+			name:   "slurp",
+			query:  `(pipe (filter (eq ("level") "info")) ((keys) ("msg")))`,
+			opts:   []Option{WithSlurp()},
+			output: `["started","retried"]`,
+		},
+		{
+			name:   "reduce",
+			query:  `(ifte (eq ("level") "error") (array $acc ("msg")) $acc)`,
+			opts:   []Option{WithReduce(`"none"`)},
+			output: `["none","failed"]`,
+		},
+		{
+			name:   "reduce last",
+			query:  `(object "last" ("msg") "previous" $acc)`,
+			opts:   []Option{WithReduce(`null`)},
+			output: `{"last":"retried","previous":{"last":"failed","previous":{"last":"started","previous":null}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := json.NewDecoder(strings.NewReader(input))
+			var buf bytes.Buffer
+			output := json.NewEncoder(&buf)
+			if err := NewApp(tt.query, input, output, tt.opts...).Run(); err != nil {
+				t.Errorf("Run() error = %v", err)
+			}
+			assert.Equal(t, tt.output, strings.TrimSpace(buf.String()))
+		})
+	}
+
+	err := NewApp(`(id)`, json.NewDecoder(strings.NewReader(input)), json.NewEncoder(ioutil.Discard), WithSlurp(), WithReduce(`null`)).Run()
+	assert.EqualError(t, err, "can't both slurp and reduce the input")
+}
+
+func TestApp_RunEvaluationError(t *testing.T) {
+	tests := []struct {
+		query  string
+		errMsg string
+	}{
+		{
+			query:  `(div 1 0)`,
+			errMsg: "couldn't get expression value for object: div failed at argument with index 1: division by zero",
+		},
+		{
+			query:  `(mod 1.5 0)`,
+			errMsg: "couldn't get expression value for object: mod failed at argument with index 1: modulo by zero",
+		},
+		{
+			query:  `(add 1 ("name"))`,
+			errMsg: "couldn't get expression value for object: add expects numbers, argument with index 1 is Poland of type string",
+		},
+		{
+			query:  `(sum (array 1 ("name")))`,
+			errMsg: "couldn't get expression value for object: sum expects numbers, element with index 1 is Poland of type string",
+		},
+		{
+			query:  `(percentile (array 1 2) 101)`,
+			errMsg: "couldn't get expression value for object: percentile percent argument should be between 0 and 100, is 101",
+		},
+		{
+			query:  `(test (sprintf "(%s" ("name")) "a")`,
+			errMsg: "couldn't get expression value for object: invalid test pattern: error parsing regexp: missing closing ): `(Poland`",
+		},
+		{
+			query:  `(tonumber ("name"))`,
+			errMsg: "couldn't get expression value for object: tonumber failed: can't convert string \"Poland\" to number",
+		},
+		{
+			query:  `(fromjson "{\"a\": 1} 2")`,
+			errMsg: "couldn't get expression value for object: fromjson failed: couldn't parse JSON: unexpected data after the document",
+		},
+		{
+			query:  `(set 1 2)`,
+			errMsg: "couldn't get expression value for object: set key argument with index 0 should be string, is 1 of type json.Number",
+		},
+		{
+			query:  `(setpath (array "name" 0) 1)`,
+			errMsg: "couldn't get expression value for object: can't use integer path element with index 1 on value Poland of type string, should be array",
+		},
+		{
+			query:  `(upper (array))`,
+			errMsg: "couldn't get expression value for object: upper expects a string as argument with index 0, got [] of type []interface {}",
+		},
+		{
+			query:  `(repeat "a" -1)`,
+			errMsg: "couldn't get expression value for object: repeat failed: repeat count can't be negative, is -1",
+		},
+		{
+			query:  `(pow 3 4611686018427387904)`,
+			errMsg: "couldn't get expression value for object: pow failed at argument with index 1: result +Inf is not a finite number",
+		},
+		{
+			query:  `(sqrt -4)`,
+			errMsg: "couldn't get expression value for object: sqrt failed: can't take the square root of negative number -4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			input := json.NewDecoder(strings.NewReader(`{"name": "Poland"}`))
+			err := NewApp(tt.query, input, json.NewEncoder(ioutil.Discard)).Run()
+			assert.EqualError(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestApp_RunParseError(t *testing.T) {
+	tests := []struct {
+		query  string
+		errMsg string
+	}{
+		{
+			query: `(elem "a" ))`,
+			errMsg: `couldn't parse query: syntax error at line 1, column 12: unexpected ')'
+(elem "a" ))
+           ^`,
+		},
+		{
+			query: `(elem "a"
+  (id) @)`,
+			errMsg: `couldn't parse query: syntax error at line 2, column 8: unexpected character '@'
+  (id) @)
+       ^`,
+		},
+		{
+			query: `(elem "a" `,
+			errMsg: `couldn't parse query: syntax error at line 1, column 11: unexpected end of query, expecting ')'
+(elem "a" 
+          ^`,
+		},
+		{
+			query: `(array 01)`,
+			errMsg: `couldn't parse query: syntax error at line 1, column 8: invalid number
+(array 01)
+       ^`,
+		},
+		{
+			query: `(elem "a\q")`,
+			errMsg: `couldn't parse query: syntax error at line 1, column 7: invalid string: invalid escape sequence \q
+(elem "a\q")
+      ^`,
+		},
+		{
+			query: `(elem "a)`,
+			errMsg: `couldn't parse query: syntax error at line 1, column 7: unterminated string
+(elem "a)
+      ^`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			app := &App{
+				query:  tt.query,
+				input:  json.NewDecoder(strings.NewReader(`{"a": 1}`)),
+				output: json.NewEncoder(ioutil.Discard),
+			}
+			err := app.Run()
+			assert.EqualError(t, err, tt.errMsg)
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package functions
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	"github.com/cube2222/jql/jql"
+)
+
+// aggregateValues evaluates the array argument of an aggregate function and projects its elements.
+// If the projection is nil, the elements are returned as they are.
+func aggregateValues(env jql.Environment, name string, array jql.Expression, projection jql.Expression, arg interface{}) ([]interface{}, error) {
+	arrayValue, err := array.Get(env, arg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't evaluate %s array argument: %w", name, err)
+	}
+	return projectElements(env, name, arrayValue, projection)
+}
+
+func projectElements(env jql.Environment, name string, arrayValue interface{}, projection jql.Expression) ([]interface{}, error) {
+	elements, ok := arrayValue.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s expects an array, got %v of type %s", name, arrayValue, reflect.TypeOf(arrayValue))
+	}
+	if projection == nil {
+		return elements, nil
+	}
+
+	out := make([]interface{}, len(elements))
+	for i := range elements {
+		if err := env.Step(); err != nil {
+			return nil, err
+		}
+		var err error
+		out[i], err = ApplyToElement(env, projection, elements[i])
+		if err != nil {
+			return nil, fmt.Errorf("couldn't evaluate %s projection for element with index %d: %w", name, i, err)
+		}
+	}
+	return out, nil
+}
+
+func checkNumbers(name string, values []interface{}) error {
+	for i := range values {
+		if jql.TypeOf(values[i]) != jql.TypeNumber {
+			return fmt.Errorf("%s expects numbers, element with index %d is %v of type %s", name, i, values[i], reflect.TypeOf(values[i]))
+		}
+	}
+	return nil
+}
+
+// optionalProjection returns the projection argument at the given index, or nil if there isn't one.
+func optionalProjection(ts []jql.Expression, i int) jql.Expression {
+	if len(ts) > i {
+		return ts[i]
+	}
+	return nil
+}
+
+type Sum struct {
+	Array      jql.Expression
+	Projection jql.Expression
+}
+
+func NewSum(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) < 1 || len(ts) > 2 {
+		return nil, fmt.Errorf("invalid argument count to sum function: %v", len(ts))
+	}
+
+	return &Sum{
+		Array:      ts[0],
+		Projection: optionalProjection(ts, 1),
+	}, nil
+}
+
+func (t Sum) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	values, err := aggregateValues(env, "sum", t.Array, t.Projection, arg)
+	if err != nil {
+		return nil, err
+	}
+	return sum(values)
+}
+
+func sum(values []interface{}) (interface{}, error) {
+	if err := checkNumbers("sum", values); err != nil {
+		return nil, err
+	}
+
+	var out interface{} = 0
+	for i := range values {
+		var err error
+		out, err = Add(out, values[i])
+		if err != nil {
+			return nil, fmt.Errorf("couldn't add element with index %d: %w", i, err)
+		}
+	}
+	return out, nil
+}
+
+// Count returns the length of the array, or the number of elements for which the projection is truthy.
+type Count struct {
+	Array      jql.Expression
+	Projection jql.Expression
+}
+
+func NewCount(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) < 1 || len(ts) > 2 {
+		return nil, fmt.Errorf("invalid argument count to count function: %v", len(ts))
+	}
+
+	return &Count{
+		Array:      ts[0],
+		Projection: optionalProjection(ts, 1),
+	}, nil
+}
+
+func (t Count) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	values, err := aggregateValues(env, "count", t.Array, t.Projection, arg)
+	if err != nil {
+		return nil, err
+	}
+	if t.Projection == nil {
+		return len(values), nil
+	}
+
+	count := 0
+	for i := range values {
+		if IsTruthy(values[i]) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Average returns the arithmetic mean of the numbers, or null for an empty array.
+type Average struct {
+	Array      jql.Expression
+	Projection jql.Expression
+}
+
+func NewAverage(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) < 1 || len(ts) > 2 {
+		return nil, fmt.Errorf("invalid argument count to avg function: %v", len(ts))
+	}
+
+	return &Average{
+		Array:      ts[0],
+		Projection: optionalProjection(ts, 1),
+	}, nil
+}
+
+func (t Average) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	values, err := aggregateValues(env, "avg", t.Array, t.Projection, arg)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	total, err := sum(values)
+	if err != nil {
+		return nil, fmt.Errorf("avg: %w", err)
+	}
+	return Divide(total, len(values))
+}
+
+// Extremum is min or max. With an array as the first argument, it returns its smallest or largest element,
+// optionally projected, or null for an empty array. Otherwise, it compares all its numeric arguments.
+type Extremum struct {
+	Name      string
+	Arguments []jql.Expression
+	Operation func(left, right interface{}) (interface{}, error)
+}
+
+func newExtremum(name string, operation func(left, right interface{}) (interface{}, error), ts []jql.Expression) (jql.Expression, error) {
+	if len(ts) < 1 {
+		return nil, fmt.Errorf("invalid argument count to %s function: %v", name, len(ts))
+	}
+
+	return &Extremum{
+		Name:      name,
+		Arguments: ts,
+		Operation: operation,
+	}, nil
+}
+
+func NewMin(ts ...jql.Expression) (jql.Expression, error) {
+	return newExtremum("min", Min, ts)
+}
+
+func NewMax(ts ...jql.Expression) (jql.Expression, error) {
+	return newExtremum("max", Max, ts)
+}
+
+func (t Extremum) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	first, err := t.Arguments[0].Get(env, arg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't evaluate %s argument with index 0: %w", t.Name, err)
+	}
+	if _, ok := first.([]interface{}); !ok {
+		return Arithmetic{Name: t.Name, Arguments: t.Arguments, Operation: t.Operation}.apply(env, arg, first)
+	}
+
+	if len(t.Arguments) > 2 {
+		return nil, fmt.Errorf("%s of an array takes at most a projection argument, got %d arguments", t.Name, len(t.Arguments))
+	}
+	values, err := projectElements(env, t.Name, first, optionalProjection(t.Arguments, 1))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNumbers(t.Name, values); err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	out := values[0]
+	for i := range values[1:] {
+		out, err = t.Operation(out, values[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("%s failed at element with index %d: %w", t.Name, i+1, err)
+		}
+	}
+	return out, nil
+}
+
+// Median returns the middle number, or the mean of the two middle ones, or null for an empty array.
+type Median struct {
+	Array      jql.Expression
+	Projection jql.Expression
+}
+
+func NewMedian(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) < 1 || len(ts) > 2 {
+		return nil, fmt.Errorf("invalid argument count to median function: %v", len(ts))
+	}
+
+	return &Median{
+		Array:      ts[0],
+		Projection: optionalProjection(ts, 1),
+	}, nil
+}
+
+func (t Median) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	values, err := aggregateValues(env, "median", t.Array, t.Projection, arg)
+	if err != nil {
+		return nil, err
+	}
+	return percentile("median", values, 50)
+}
+
+// Percentile returns the number below which the given percentage of the numbers fall,
+// interpolating linearly between the closest ones, or null for an empty array.
+type Percentile struct {
+	Array      jql.Expression
+	Percent    jql.Expression
+	Projection jql.Expression
+}
+
+func NewPercentile(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) < 2 || len(ts) > 3 {
+		return nil, fmt.Errorf("invalid argument count to percentile function: %v", len(ts))
+	}
+
+	return &Percentile{
+		Array:      ts[0],
+		Percent:    ts[1],
+		Projection: optionalProjection(ts, 2),
+	}, nil
+}
+
+func (t Percentile) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	percentValue, err := t.Percent.Get(env, arg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't evaluate percentile percent argument: %w", err)
+	}
+	percent, err := Floatify(percentValue)
+	if err != nil {
+		return nil, fmt.Errorf("percentile expected number percent argument: %w", err)
+	}
+	if percent < 0 || percent > 100 || math.IsNaN(percent) {
+		return nil, fmt.Errorf("percentile percent argument should be between 0 and 100, is %v", percentValue)
+	}
+
+	values, err := aggregateValues(env, "percentile", t.Array, t.Projection, arg)
+	if err != nil {
+		return nil, err
+	}
+	return percentile("percentile", values, percent)
+}
+
+func percentile(name string, values []interface{}, percent float64) (interface{}, error) {
+	if err := checkNumbers(name, values); err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	sorted := make([]interface{}, len(values))
+	copy(sorted, values)
+	var compareErr error
+	sort.SliceStable(sorted, func(i, j int) bool {
+		cmp, err := CompareNumbers(sorted[i], sorted[j])
+		if err != nil && compareErr == nil {
+			compareErr = err
+		}
+		return cmp < 0
+	})
+	if compareErr != nil {
+		return nil, fmt.Errorf("couldn't sort %s numbers: %w", name, compareErr)
+	}
+
+	rank := percent / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	fraction := rank - float64(lower)
+	if fraction == 0 {
+		return sorted[lower], nil
+	}
+	if percent == 50 && len(sorted)%2 == 0 {
+		// The median of an even count is the mean of the middle numbers, which stays exact for integers.
+		total, err := Add(sorted[lower], sorted[lower+1])
+		if err != nil {
+			return nil, err
+		}
+		return Divide(total, 2)
+	}
+
+	difference, err := Subtract(sorted[lower+1], sorted[lower])
+	if err != nil {
+		return nil, err
+	}
+	offset, err := Multiply(difference, fraction)
+	if err != nil {
+		return nil, err
+	}
+	return Add(sorted[lower], offset)
+}
@@ -1,36 +1,816 @@
 package functions
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
 	"reflect"
 	"runtime/debug"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/cube2222/jql/jql"
 )
 
-var Functions = map[string]func(ts ...jql.Expression) (jql.Expression, error){
-	"elem":    NewElement,
-	"keys":    NewKeys,
-	"id":      NewIdentity,
-	"array":   NewArray,
-	"object":  NewObject,
-	"pipe":    NewPipe,
-	"sprintf": NewSprintf,
-	"join":    NewJoin,
-	"filter":  NewFilter,
-	"eq":      NewEqual,
-	"lt":      NewLessThan,
-	"gt":      NewGreaterThan,
-	"range":   NewRange,
-	"and":     NewAnd,
-	"or":      NewOr,
-	"not":     NewNot,
-	"ifte":    NewIfTE,
-	"error":   NewError,
-	"recover": NewRecover,
-	"zip":     NewZip,
+// DefaultRegistry returns a new registry with all the built-in functions, which can be freely extended or restricted.
+func DefaultRegistry() *jql.FunctionRegistry {
+	registry, err := jql.NewFunctionRegistry(Builtins()...)
+	if err != nil {
+		panic(fmt.Sprintf("invalid built-in functions: %s", err))
+	}
+	return registry
+}
+
+// Builtins returns the descriptions of all the built-in functions.
+func Builtins() []*jql.Function {
+	return []*jql.Function{
+		{
+			Name:        "elem",
+			Constructor: NewElement,
+			MinArgs:     1,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeString | jql.TypeNumber | jql.TypeArray | jql.TypeObject, jql.TypeAny},
+			ReturnType:  jql.TypeAny,
+			Doc:         "Gets the elements at the given positions, optionally transforming each with the second argument, evaluated in the context of the element.",
+		},
+		{
+			Name:        "keys",
+			Constructor: NewKeys,
+			ReturnType:  jql.TypeArray,
+			Doc:         "Returns the indices of an array or the sorted field names of an object.",
+		},
+		{
+			Name:        "id",
+			Constructor: NewIdentity,
+			ReturnType:  jql.TypeAny,
+			Doc:         "Returns the current context unchanged.",
+		},
+		{
+			Name:        "array",
+			Constructor: NewArray,
+			MaxArgs:     -1,
+			ReturnType:  jql.TypeArray,
+			Doc:         "Creates an array out of its arguments.",
+		},
+		{
+			Name:        "object",
+			Constructor: NewObject,
+			MaxArgs:     -1,
+			ReturnType:  jql.TypeObject,
+			Doc:         "Creates an object out of alternating key and value arguments.",
+		},
+		{
+			Name:        "pipe",
+			Constructor: NewPipe,
+			MinArgs:     1,
+			MaxArgs:     -1,
+			ReturnType:  jql.TypeAny,
+			Doc:         "Evaluates each argument in the context of the result of the previous one.",
+		},
+		{
+			Name:        "sprintf",
+			Constructor: NewSprintf,
+			MinArgs:     1,
+			MaxArgs:     -1,
+			ArgTypes:    []jql.Type{jql.TypeString, jql.TypeAny},
+			ReturnType:  jql.TypeString,
+			Doc:         "Formats the arguments according to the format string, like Go's fmt.Sprintf.",
+		},
+		{
+			Name:        "join",
+			Constructor: NewJoin,
+			MinArgs:     1,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeAny, jql.TypeString},
+			ReturnType:  jql.TypeString,
+			Doc:         "Stringifies the elements of an array and joins them with the optional separator.",
+		},
+		{
+			Name:        "filter",
+			Constructor: NewFilter,
+			MinArgs:     1,
+			MaxArgs:     2,
+			ReturnType:  jql.TypeArray,
+			Doc:         "Keeps the array elements for which the predicate, evaluated in the context of the element, is truthy, optionally projecting each kept element with the second argument.",
+		},
+		{
+			Name:        "map",
+			Constructor: NewMap,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeArray | jql.TypeObject,
+			Doc:         "Evaluates the argument in the context of each array element or object value.",
+		},
+		{
+			Name:        "eq",
+			Constructor: NewEqual,
+			MinArgs:     2,
+			MaxArgs:     2,
+			ReturnType:  jql.TypeBool,
+			Doc:         "Checks whether both arguments are deeply equal.",
+		},
+		{
+			Name:        "lt",
+			Constructor: NewLessThan,
+			MinArgs:     2,
+			MaxArgs:     2,
+			ReturnType:  jql.TypeBool,
+			Doc:         "Checks whether the first argument is less than the second one, in the total order of values: null < bool < number < string < array < object.",
+		},
+		{
+			Name:        "gt",
+			Constructor: NewGreaterThan,
+			MinArgs:     2,
+			MaxArgs:     2,
+			ReturnType:  jql.TypeBool,
+			Doc:         "Checks whether the first argument is greater than the second one, in the total order of values: null < bool < number < string < array < object.",
+		},
+		{
+			Name:        "range",
+			Constructor: NewRange,
+			MinArgs:     1,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeNumber},
+			ReturnType:  jql.TypeArray,
+			Doc:         "Returns the integers from begin (0 by default) up to, but not including, end.",
+		},
+		{
+			Name:        "and",
+			Constructor: NewAnd,
+			MaxArgs:     -1,
+			ReturnType:  jql.TypeBool,
+			Doc:         "Checks whether all arguments are truthy, lazily.",
+		},
+		{
+			Name:        "or",
+			Constructor: NewOr,
+			MaxArgs:     -1,
+			ReturnType:  jql.TypeBool,
+			Doc:         "Checks whether any argument is truthy, lazily.",
+		},
+		{
+			Name:        "not",
+			Constructor: NewNot,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeBool,
+			Doc:         "Negates the truthiness of its argument.",
+		},
+		{
+			Name:        "ifte",
+			Constructor: NewIfTE,
+			MinArgs:     3,
+			MaxArgs:     3,
+			ReturnType:  jql.TypeAny,
+			Doc:         "Evaluates the second argument if the first one is truthy, the third one otherwise.",
+		},
+		{
+			Name:        "error",
+			Constructor: NewError,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeAny,
+			Doc:         "Fails with the given message and a stack trace.",
+		},
+		{
+			Name:        "recover",
+			Constructor: NewRecover,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeAny,
+			Doc:         "Returns null if evaluating its argument fails.",
+		},
+		{
+			Name:        "zip",
+			Constructor: NewZip,
+			MinArgs:     1,
+			MaxArgs:     -1,
+			ArgTypes:    []jql.Type{jql.TypeArray},
+			ReturnType:  jql.TypeArray,
+			Doc:         "Combines arrays into an array of tuples, as long as the shortest one.",
+		},
+		{
+			Name:        "call",
+			Constructor: NewCall,
+			MinArgs:     1,
+			MaxArgs:     -1,
+			ArgTypes:    []jql.Type{jql.TypeFunction, jql.TypeAny},
+			ReturnType:  jql.TypeAny,
+			Doc:         "Calls a function value with the given arguments.",
+		},
+		{
+			Name:        "sort",
+			Constructor: NewSort,
+			ReturnType:  jql.TypeArray,
+			Doc:         "Stably sorts the array in the current context, in the total order of values used by lt and gt.",
+		},
+		{
+			Name:        "sortby",
+			Constructor: NewSortBy,
+			MinArgs:     1,
+			MaxArgs:     -1,
+			ReturnType:  jql.TypeArray,
+			Doc:         "Stably sorts the array in the current context by the keys, each evaluated in the context of the element. Wrap a key in desc to sort by it in descending order.",
+		},
+		{
+			Name:        "desc",
+			Constructor: NewDescending,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeAny,
+			Doc:         "Marks a sortby key as descending, evaluates to its argument anywhere else.",
+		},
+		{
+			Name:        "groupby",
+			Constructor: NewGroupBy,
+			MinArgs:     1,
+			MaxArgs:     2,
+			ReturnType:  jql.TypeObject,
+			Doc:         "Groups the array in the current context into an object keyed by the stringified key, evaluated in the context of each element. The optional second argument is evaluated in the context of each group's array.",
+		},
+		{
+			Name:        "groupentries",
+			Constructor: NewGroupEntries,
+			MinArgs:     1,
+			MaxArgs:     2,
+			ReturnType:  jql.TypeArray,
+			Doc:         "Like groupby, but returns an array of {\"key\": ..., \"values\": ...} objects sorted by key, keeping the keys as they are.",
+		},
+		{
+			Name:        "upper",
+			Constructor: NewUpper,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeString,
+			Doc:         "Converts the string to upper case.",
+		},
+		{
+			Name:        "lower",
+			Constructor: NewLower,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeString,
+			Doc:         "Converts the string to lower case.",
+		},
+		{
+			Name:        "trim",
+			Constructor: NewTrim,
+			MinArgs:     1,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeString,
+			Doc:         "Removes leading and trailing whitespace, or the runes in the optional second argument.",
+		},
+		{
+			Name:        "ltrim",
+			Constructor: NewTrimLeft,
+			MinArgs:     1,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeString,
+			Doc:         "Removes leading whitespace, or the runes in the optional second argument.",
+		},
+		{
+			Name:        "rtrim",
+			Constructor: NewTrimRight,
+			MinArgs:     1,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeString,
+			Doc:         "Removes trailing whitespace, or the runes in the optional second argument.",
+		},
+		{
+			Name:        "split",
+			Constructor: NewSplit,
+			MinArgs:     1,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeArray,
+			Doc:         "Splits the string around each occurrence of the separator, or around whitespace if there's none.",
+		},
+		{
+			Name:        "replace",
+			Constructor: NewReplace,
+			MinArgs:     3,
+			MaxArgs:     3,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeString,
+			Doc:         "Replaces all occurrences of the second argument in the string with the third one.",
+		},
+		{
+			Name:        "substr",
+			Constructor: NewSubstr,
+			MinArgs:     2,
+			MaxArgs:     3,
+			ArgTypes:    []jql.Type{jql.TypeString, jql.TypeNumber},
+			ReturnType:  jql.TypeString,
+			Doc:         "Returns the part of the string starting at the rune index, counting from the end if it's negative, optionally limited to the given number of runes.",
+		},
+		{
+			Name:        "contains",
+			Constructor: NewContains,
+			MinArgs:     2,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeBool,
+			Doc:         "Checks whether the string contains the second argument.",
+		},
+		{
+			Name:        "startswith",
+			Constructor: NewStartsWith,
+			MinArgs:     2,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeBool,
+			Doc:         "Checks whether the string starts with the second argument.",
+		},
+		{
+			Name:        "endswith",
+			Constructor: NewEndsWith,
+			MinArgs:     2,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeBool,
+			Doc:         "Checks whether the string ends with the second argument.",
+		},
+		{
+			Name:        "length",
+			Constructor: NewLength,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ArgTypes:    []jql.Type{jql.TypeString | jql.TypeArray | jql.TypeObject},
+			ReturnType:  jql.TypeNumber,
+			Doc:         "Returns the number of runes in a string, or the number of elements in an array or object.",
+		},
+		{
+			Name:        "repeat",
+			Constructor: NewRepeat,
+			MinArgs:     2,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeString, jql.TypeNumber},
+			ReturnType:  jql.TypeString,
+			Doc:         "Repeats the string the given number of times.",
+		},
+		{
+			Name:        "padleft",
+			Constructor: NewPadLeft,
+			MinArgs:     2,
+			MaxArgs:     3,
+			ArgTypes:    []jql.Type{jql.TypeString, jql.TypeNumber, jql.TypeString},
+			ReturnType:  jql.TypeString,
+			Doc:         "Pads the string on the left to the given width in runes, with spaces or the optional pad string.",
+		},
+		{
+			Name:        "padright",
+			Constructor: NewPadRight,
+			MinArgs:     2,
+			MaxArgs:     3,
+			ArgTypes:    []jql.Type{jql.TypeString, jql.TypeNumber, jql.TypeString},
+			ReturnType:  jql.TypeString,
+			Doc:         "Pads the string on the right to the given width in runes, with spaces or the optional pad string.",
+		},
+		{
+			Name:        "indexof",
+			Constructor: NewIndexOf,
+			MinArgs:     2,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeNumber,
+			Doc:         "Returns the rune index of the first occurrence of the second argument in the string, or -1 if there's none.",
+		},
+		{
+			Name:        "test",
+			Constructor: NewTest,
+			MinArgs:     2,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeBool,
+			Doc:         "Returns whether the regular expression in the first argument matches the string.",
+		},
+		{
+			Name:        "match",
+			Constructor: NewMatch,
+			MinArgs:     2,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeObject | jql.TypeNull,
+			Doc:         "Returns the first match of the regular expression as an object with the whole match, its capture groups and its named capture groups, or null if there's none.",
+		},
+		{
+			Name:        "scan",
+			Constructor: NewScan,
+			MinArgs:     2,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeArray,
+			Doc:         "Returns all the matches of the regular expression, or the arrays of their capture groups if it has any.",
+		},
+		{
+			Name:        "regsub",
+			Constructor: NewRegsub,
+			MinArgs:     3,
+			MaxArgs:     3,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeString,
+			Doc:         "Replaces all the matches of the regular expression with the third argument, which can refer to capture groups like $1 or ${name}.",
+		},
+		{
+			Name:        "type",
+			Constructor: NewType,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeString,
+			Doc:         "Returns the name of the type of the value: \"null\", \"boolean\", \"number\", \"string\", \"array\" or \"object\".",
+		},
+		{
+			Name:        "isnull",
+			Constructor: NewIsNull,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeBool,
+			Doc:         "Returns whether the value is null.",
+		},
+		{
+			Name:        "isboolean",
+			Constructor: NewIsBoolean,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeBool,
+			Doc:         "Returns whether the value is a boolean.",
+		},
+		{
+			Name:        "isnumber",
+			Constructor: NewIsNumber,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeBool,
+			Doc:         "Returns whether the value is a number.",
+		},
+		{
+			Name:        "isstring",
+			Constructor: NewIsString,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeBool,
+			Doc:         "Returns whether the value is a string.",
+		},
+		{
+			Name:        "isarray",
+			Constructor: NewIsArray,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeBool,
+			Doc:         "Returns whether the value is an array.",
+		},
+		{
+			Name:        "isobject",
+			Constructor: NewIsObject,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeBool,
+			Doc:         "Returns whether the value is an object.",
+		},
+		{
+			Name:        "tostring",
+			Constructor: NewToString,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeString,
+			Doc:         "Returns strings as they are, and the JSON encoding of any other value.",
+		},
+		{
+			Name:        "tonumber",
+			Constructor: NewToNumber,
+			MinArgs:     1,
+			MaxArgs:     2,
+			ReturnType:  jql.TypeAny,
+			Doc:         "Parses strings holding numbers and returns numbers as they are. Returns the second argument if the value can't be converted, fails if there's none.",
+		},
+		{
+			Name:        "tobool",
+			Constructor: NewToBool,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeBool,
+			Doc:         "Parses strings like \"true\" or \"0\", and converts other values by their truthiness.",
+		},
+		{
+			Name:        "tojson",
+			Constructor: NewToJSON,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeString,
+			Doc:         "Serializes the value to JSON.",
+		},
+		{
+			Name:        "fromjson",
+			Constructor: NewFromJSON,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeAny,
+			Doc:         "Parses the JSON document in the string.",
+		},
+		{
+			Name:        "set",
+			Constructor: NewSet,
+			MinArgs:     2,
+			MaxArgs:     -1,
+			ReturnType:  jql.TypeObject,
+			Doc:         "Returns the object in the current context with each key argument set to the value following it.",
+		},
+		{
+			Name:        "assoc",
+			Constructor: NewAssoc,
+			MinArgs:     2,
+			MaxArgs:     -1,
+			ReturnType:  jql.TypeObject,
+			Doc:         "Returns the object in the current context with each key argument set to the value following it.",
+		},
+		{
+			Name:        "delete",
+			Constructor: NewDelete,
+			MinArgs:     1,
+			MaxArgs:     -1,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeObject,
+			Doc:         "Returns the object in the current context without the given keys.",
+		},
+		{
+			Name:        "omit",
+			Constructor: NewOmit,
+			MinArgs:     1,
+			MaxArgs:     -1,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeObject,
+			Doc:         "Returns the object in the current context without the given keys.",
+		},
+		{
+			Name:        "pick",
+			Constructor: NewPick,
+			MinArgs:     1,
+			MaxArgs:     -1,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeObject,
+			Doc:         "Returns an object with only the given keys of the object in the current context.",
+		},
+		{
+			Name:        "rename",
+			Constructor: NewRename,
+			MinArgs:     2,
+			MaxArgs:     -1,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeObject,
+			Doc:         "Returns the object in the current context with each key argument renamed to the key following it.",
+		},
+		{
+			Name:        "has",
+			Constructor: NewHas,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ArgTypes:    []jql.Type{jql.TypeString},
+			ReturnType:  jql.TypeBool,
+			Doc:         "Returns whether the object in the current context has the key.",
+		},
+		{
+			Name:        "merge",
+			Constructor: NewMerge,
+			MinArgs:     1,
+			MaxArgs:     -1,
+			ArgTypes:    []jql.Type{jql.TypeObject},
+			ReturnType:  jql.TypeObject,
+			Doc:         "Merges the objects, later fields override earlier ones.",
+		},
+		{
+			Name:        "deepmerge",
+			Constructor: NewDeepMerge,
+			MinArgs:     1,
+			MaxArgs:     -1,
+			ArgTypes:    []jql.Type{jql.TypeObject},
+			ReturnType:  jql.TypeObject,
+			Doc:         "Merges the objects, recursively merging fields which are objects in both.",
+		},
+		{
+			Name:        "getpath",
+			Constructor: NewGetPath,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ArgTypes:    []jql.Type{jql.TypeArray},
+			ReturnType:  jql.TypeAny,
+			Doc:         "Returns the value at the path, an array of keys and indices, or null if there's none.",
+		},
+		{
+			Name:        "setpath",
+			Constructor: NewSetPath,
+			MinArgs:     2,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeArray, jql.TypeAny},
+			ReturnType:  jql.TypeAny,
+			Doc:         "Returns the current context with the value at the path set to the second argument, creating missing objects and arrays.",
+		},
+		{
+			Name:        "delpath",
+			Constructor: NewDeletePath,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ArgTypes:    []jql.Type{jql.TypeArray},
+			ReturnType:  jql.TypeAny,
+			Doc:         "Returns the current context without the value at the path.",
+		},
+		{
+			Name:        "paths",
+			Constructor: NewPaths,
+			MinArgs:     0,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeArray,
+			Doc:         "Returns the paths of all the leaves in the current context, optionally only the ones for which the predicate is truthy.",
+		},
+		{
+			Name:        "walk",
+			Constructor: NewWalk,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ReturnType:  jql.TypeAny,
+			Doc:         "Applies the expression to every value in the current context, bottom-up.",
+		},
+		{
+			Name:        "add",
+			Constructor: NewAdd,
+			MinArgs:     1,
+			MaxArgs:     -1,
+			ArgTypes:    []jql.Type{jql.TypeNumber},
+			ReturnType:  jql.TypeNumber,
+			Doc:         "Adds the numbers.",
+		},
+		{
+			Name:        "sub",
+			Constructor: NewSubtract,
+			MinArgs:     1,
+			MaxArgs:     -1,
+			ArgTypes:    []jql.Type{jql.TypeNumber},
+			ReturnType:  jql.TypeNumber,
+			Doc:         "Subtracts the rest of the numbers from the first one, or negates a single number.",
+		},
+		{
+			Name:        "mul",
+			Constructor: NewMultiply,
+			MinArgs:     1,
+			MaxArgs:     -1,
+			ArgTypes:    []jql.Type{jql.TypeNumber},
+			ReturnType:  jql.TypeNumber,
+			Doc:         "Multiplies the numbers.",
+		},
+		{
+			Name:        "div",
+			Constructor: NewDivide,
+			MinArgs:     2,
+			MaxArgs:     -1,
+			ArgTypes:    []jql.Type{jql.TypeNumber},
+			ReturnType:  jql.TypeNumber,
+			Doc:         "Divides the first number by the rest, the result is an integer only if the division is exact.",
+		},
+		{
+			Name:        "mod",
+			Constructor: NewModulo,
+			MinArgs:     2,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeNumber},
+			ReturnType:  jql.TypeNumber,
+			Doc:         "Returns the remainder of dividing the first number by the second one, with the sign of the first one.",
+		},
+		{
+			Name:        "neg",
+			Constructor: NewNegate,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ArgTypes:    []jql.Type{jql.TypeNumber},
+			ReturnType:  jql.TypeNumber,
+			Doc:         "Negates the number.",
+		},
+		{
+			Name:        "abs",
+			Constructor: NewAbs,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ArgTypes:    []jql.Type{jql.TypeNumber},
+			ReturnType:  jql.TypeNumber,
+			Doc:         "Returns the absolute value of the number.",
+		},
+		{
+			Name:        "min",
+			Constructor: NewMin,
+			MinArgs:     1,
+			MaxArgs:     -1,
+			ArgTypes:    []jql.Type{jql.TypeNumber | jql.TypeArray, jql.TypeAny},
+			ReturnType:  jql.TypeNumber | jql.TypeNull,
+			Doc:         "Returns the smallest of the numbers, or the smallest element of an array, optionally projected, null if it's empty.",
+		},
+		{
+			Name:        "max",
+			Constructor: NewMax,
+			MinArgs:     1,
+			MaxArgs:     -1,
+			ArgTypes:    []jql.Type{jql.TypeNumber | jql.TypeArray, jql.TypeAny},
+			ReturnType:  jql.TypeNumber | jql.TypeNull,
+			Doc:         "Returns the largest of the numbers, or the largest element of an array, optionally projected, null if it's empty.",
+		},
+		{
+			Name:        "pow",
+			Constructor: NewPower,
+			MinArgs:     2,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeNumber},
+			ReturnType:  jql.TypeNumber,
+			Doc:         "Raises the first number to the power of the second one.",
+		},
+		{
+			Name:        "floor",
+			Constructor: NewFloor,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ArgTypes:    []jql.Type{jql.TypeNumber},
+			ReturnType:  jql.TypeNumber,
+			Doc:         "Rounds the number down to an integer.",
+		},
+		{
+			Name:        "ceil",
+			Constructor: NewCeil,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ArgTypes:    []jql.Type{jql.TypeNumber},
+			ReturnType:  jql.TypeNumber,
+			Doc:         "Rounds the number up to an integer.",
+		},
+		{
+			Name:        "round",
+			Constructor: NewRound,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ArgTypes:    []jql.Type{jql.TypeNumber},
+			ReturnType:  jql.TypeNumber,
+			Doc:         "Rounds the number to the nearest integer, halves away from zero.",
+		},
+		{
+			Name:        "sqrt",
+			Constructor: NewSqrt,
+			MinArgs:     1,
+			MaxArgs:     1,
+			ArgTypes:    []jql.Type{jql.TypeNumber},
+			ReturnType:  jql.TypeNumber,
+			Doc:         "Returns the square root of the number.",
+		},
+		{
+			Name:        "sum",
+			Constructor: NewSum,
+			MinArgs:     1,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeArray, jql.TypeAny},
+			ReturnType:  jql.TypeNumber,
+			Doc:         "Adds the numbers in the array, optionally projecting each element with the second argument, evaluated in the context of the element.",
+		},
+		{
+			Name:        "count",
+			Constructor: NewCount,
+			MinArgs:     1,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeArray, jql.TypeAny},
+			ReturnType:  jql.TypeNumber,
+			Doc:         "Returns the length of the array, or the number of elements for which the projection is truthy.",
+		},
+		{
+			Name:        "avg",
+			Constructor: NewAverage,
+			MinArgs:     1,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeArray, jql.TypeAny},
+			ReturnType:  jql.TypeNumber | jql.TypeNull,
+			Doc:         "Returns the mean of the numbers in the array, optionally projected, null if it's empty.",
+		},
+		{
+			Name:        "median",
+			Constructor: NewMedian,
+			MinArgs:     1,
+			MaxArgs:     2,
+			ArgTypes:    []jql.Type{jql.TypeArray, jql.TypeAny},
+			ReturnType:  jql.TypeNumber | jql.TypeNull,
+			Doc:         "Returns the median of the numbers in the array, optionally projected, null if it's empty.",
+		},
+		{
+			Name:        "percentile",
+			Constructor: NewPercentile,
+			MinArgs:     2,
+			MaxArgs:     3,
+			ArgTypes:    []jql.Type{jql.TypeArray, jql.TypeNumber, jql.TypeAny},
+			ReturnType:  jql.TypeNumber | jql.TypeNull,
+			Doc:         "Returns the given percentile (0-100) of the numbers in the array, optionally projected, interpolating linearly, null if it's empty.",
+		},
+	}
 }
 
 type Element struct {
@@ -55,13 +835,19 @@ func NewElement(ts ...jql.Expression) (jql.Expression, error) {
 	}
 }
 
-func GetElement(positions interface{}, argument interface{}, leafExpression jql.Expression) (interface{}, error) {
+func GetElement(env jql.Environment, positions interface{}, argument interface{}, leafExpression jql.Expression) (interface{}, error) {
 	switch positionTyped := positions.(type) {
 	case []interface{}:
+		if err := env.ChargeElements(len(positionTyped)); err != nil {
+			return nil, err
+		}
 		outArray := make([]interface{}, len(positionTyped))
 		for i := range positionTyped {
+			if err := env.Step(); err != nil {
+				return nil, err
+			}
 			var err error
-			outArray[i], err = GetElement(positionTyped[i], argument, leafExpression)
+			outArray[i], err = GetElement(env, positionTyped[i], argument, leafExpression)
 			if err != nil {
 				return nil, fmt.Errorf("couldn't get element using position at array index %d: %w", i, err)
 			}
@@ -70,10 +856,16 @@ func GetElement(positions interface{}, argument interface{}, leafExpression jql.
 		return outArray, nil
 
 	case map[string]interface{}:
+		if err := env.ChargeElements(len(positionTyped)); err != nil {
+			return nil, err
+		}
 		outObject := make(map[string]interface{}, len(positionTyped))
 		for k := range positionTyped {
+			if err := env.Step(); err != nil {
+				return nil, err
+			}
 			var err error
-			outObject[k], err = GetElement(positionTyped[k], argument, leafExpression)
+			outObject[k], err = GetElement(env, positionTyped[k], argument, leafExpression)
 			if err != nil {
 				return nil, fmt.Errorf("couldn't get element using position at object field %s: %w", k, err)
 			}
@@ -81,19 +873,24 @@ func GetElement(positions interface{}, argument interface{}, leafExpression jql.
 
 		return outObject, nil
 
-	case int:
+	case int, float64, json.Number:
+		index, err := Intify(positionTyped)
+		if err != nil {
+			return nil, fmt.Errorf("invalid array position: %w", err)
+		}
+
 		arr, ok := argument.([]interface{})
 		if !ok {
 			return nil, fmt.Errorf("can't use integer position with argument %v of type %s, should be array", argument, reflect.TypeOf(argument))
 		}
 
-		if len(arr) <= positionTyped {
+		if index < 0 || len(arr) <= index {
 			return nil, nil
 		}
 
-		out, err := leafExpression.Get(arr[positionTyped])
+		out, err := leafExpression.Get(env, arr[index])
 		if err != nil {
-			return nil, fmt.Errorf("couldn't get transformed value for index %d with value %v: %w", positionTyped, arr[positionTyped], err)
+			return nil, fmt.Errorf("couldn't get transformed value for index %d with value %v: %w", index, arr[index], err)
 		}
 		return out, nil
 
@@ -108,7 +905,7 @@ func GetElement(positions interface{}, argument interface{}, leafExpression jql.
 			return nil, nil
 		}
 
-		out, err := leafExpression.Get(valueExpressionArgument)
+		out, err := leafExpression.Get(env, valueExpressionArgument)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't get transformed value for field %s with value %v: %w", positionTyped, valueExpressionArgument, err)
 		}
@@ -119,13 +916,13 @@ func GetElement(positions interface{}, argument interface{}, leafExpression jql.
 	}
 }
 
-func (t Element) Get(arg interface{}) (interface{}, error) {
-	positions, err := t.Positions.Get(arg)
+func (t Element) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	positions, err := t.Positions.Get(env, arg)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't get positions to get: %w", err)
 	}
 
-	return GetElement(positions, arg, t.ValueExpression)
+	return GetElement(env, positions, arg, t.ValueExpression)
 }
 
 type Keys struct {
@@ -139,9 +936,12 @@ func NewKeys(ts ...jql.Expression) (jql.Expression, error) {
 	return Keys{}, nil
 }
 
-func (s Keys) Get(arg interface{}) (interface{}, error) {
+func (s Keys) Get(env jql.Environment, arg interface{}) (interface{}, error) {
 	switch typed := arg.(type) {
 	case []interface{}:
+		if err := env.ChargeElements(len(typed)); err != nil {
+			return nil, err
+		}
 		outIndices := make([]interface{}, len(typed))
 		for i := range typed {
 			outIndices[i] = i
@@ -150,15 +950,10 @@ func (s Keys) Get(arg interface{}) (interface{}, error) {
 		return outIndices, nil
 
 	case map[string]interface{}:
-		outFields := make([]interface{}, 0, len(typed))
-		for field := range typed {
-			outFields = append(outFields, field)
+		if err := env.ChargeElements(len(typed)); err != nil {
+			return nil, err
 		}
-		sort.Slice(outFields, func(i, j int) bool {
-			return outFields[i].(string) < outFields[j].(string)
-		})
-
-		return outFields, nil
+		return sortedKeys(typed), nil
 
 	default:
 		return nil, fmt.Errorf("can only use keys on array or object, used on: %s", reflect.TypeOf(arg))
@@ -176,7 +971,7 @@ func NewIdentity(ts ...jql.Expression) (jql.Expression, error) {
 	return Identity{}, nil
 }
 
-func (t Identity) Get(arg interface{}) (interface{}, error) {
+func (t Identity) Get(env jql.Environment, arg interface{}) (interface{}, error) {
 	return arg, nil
 }
 
@@ -188,11 +983,17 @@ func NewArray(ts ...jql.Expression) (jql.Expression, error) {
 	return Array{Values: ts}, nil
 }
 
-func (t Array) Get(arg interface{}) (interface{}, error) {
+func (t Array) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	if err := env.ChargeElements(len(t.Values)); err != nil {
+		return nil, err
+	}
 	outArray := make([]interface{}, len(t.Values))
 	for i := range t.Values {
+		if err := env.Step(); err != nil {
+			return nil, err
+		}
 		var err error
-		outArray[i], err = t.Values[i].Get(arg)
+		outArray[i], err = t.Values[i].Get(env, arg)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't construct array index %d: %w", i, err)
 		}
@@ -222,10 +1023,16 @@ func NewObject(ts ...jql.Expression) (jql.Expression, error) {
 	return Object{Keys: keys, Values: values}, nil
 }
 
-func (t Object) Get(arg interface{}) (interface{}, error) {
+func (t Object) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	if err := env.ChargeElements(len(t.Values)); err != nil {
+		return nil, err
+	}
 	outObject := make(map[string]interface{}, len(t.Values))
 	for i, keyExpression := range t.Keys {
-		keyValue, err := keyExpression.Get(arg)
+		if err := env.Step(); err != nil {
+			return nil, err
+		}
+		keyValue, err := keyExpression.Get(env, arg)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't get key out of key expression with index %d: %w", i, err)
 		}
@@ -235,7 +1042,7 @@ func (t Object) Get(arg interface{}) (interface{}, error) {
 			return nil, fmt.Errorf("got object key %v of type %s at position %d, must be string", keyValue, reflect.TypeOf(keyValue), i)
 		}
 
-		outObject[key], err = t.Values[i].Get(arg)
+		outObject[key], err = t.Values[i].Get(env, arg)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't construct object field %s at index %d: %w", key, i, err)
 		}
@@ -255,11 +1062,14 @@ func NewPipe(ts ...jql.Expression) (jql.Expression, error) {
 	return Pipe{Expressions: ts}, nil
 }
 
-func (t Pipe) Get(arg interface{}) (interface{}, error) {
+func (t Pipe) Get(env jql.Environment, arg interface{}) (interface{}, error) {
 	object := arg
 	for i := range t.Expressions {
+		if err := env.Step(); err != nil {
+			return nil, err
+		}
 		var err error
-		object, err = t.Expressions[i].Get(object)
+		object, err = t.Expressions[i].Get(env, object)
 		if err != nil {
 			return nil, fmt.Errorf("error in pipe subexpression with index %d: %w", i, err)
 		}
@@ -279,8 +1089,8 @@ func NewSprintf(ts ...jql.Expression) (jql.Expression, error) {
 	return Sprintf{Format: ts[0], Expressions: ts[1:]}, nil
 }
 
-func (t Sprintf) Get(arg interface{}) (interface{}, error) {
-	formatValue, err := t.Format.Get(arg)
+func (t Sprintf) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	formatValue, err := t.Format.Get(env, arg)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't evaluate sprintf format argument: %w", err)
 	}
@@ -292,12 +1102,54 @@ func (t Sprintf) Get(arg interface{}) (interface{}, error) {
 	values := make([]interface{}, len(t.Expressions))
 	for i := range t.Expressions {
 		var err error
-		values[i], err = t.Expressions[i].Get(arg)
+		values[i], err = t.Expressions[i].Get(env, arg)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't evaluate sprintf argument with index %d: %w", i, err)
 		}
+		if number, ok := values[i].(json.Number); ok {
+			values[i] = exactNumber(number)
+		}
+	}
+	// The values are wrapped so the length of the output is checked while it's being built,
+	// before it can grow far past the limit.
+	output := &boundedOutput{env: env}
+	for i := range values {
+		values[i] = boundedValue{value: values[i], output: output}
+	}
+	out := fmt.Sprintf(format, values...)
+	if output.err != nil {
+		return nil, output.err
+	}
+	if err := env.CheckStringLength(len(out)); err != nil {
+		return nil, err
 	}
-	return fmt.Sprintf(format, values...), nil
+	return out, nil
+}
+
+// boundedOutput tracks the total length of the formatted sprintf arguments.
+type boundedOutput struct {
+	env    jql.Environment
+	length int
+	err    error
+}
+
+// boundedValue formats a sprintf argument on its own and writes it out only if the arguments formatted so far fit in the string length limit.
+type boundedValue struct {
+	value  interface{}
+	output *boundedOutput
+}
+
+func (v boundedValue) Format(f fmt.State, verb rune) {
+	if v.output.err != nil {
+		return
+	}
+	formatted := fmt.Sprintf(formatDirective(f, verb), v.value)
+	v.output.length += len(formatted)
+	if err := v.output.env.CheckStringLength(v.output.length); err != nil {
+		v.output.err = err
+		return
+	}
+	io.WriteString(f, formatted)
 }
 
 type Join struct {
@@ -322,8 +1174,8 @@ func NewJoin(ts ...jql.Expression) (jql.Expression, error) {
 	}
 }
 
-func (t Join) Get(arg interface{}) (interface{}, error) {
-	separatorValue, err := t.Separator.Get(arg)
+func (t Join) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	separatorValue, err := t.Separator.Get(env, arg)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't evaluate join separator argument: %w", err)
 	}
@@ -332,7 +1184,7 @@ func (t Join) Get(arg interface{}) (interface{}, error) {
 		return nil, fmt.Errorf("join separator argument should be string, is %v of type %s", separatorValue, reflect.TypeOf(separatorValue))
 	}
 
-	argsValue, err := t.Strings.Get(arg)
+	argsValue, err := t.Strings.Get(env, arg)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't evaluate join strings argument: %w", err)
 	}
@@ -342,8 +1194,13 @@ func (t Join) Get(arg interface{}) (interface{}, error) {
 	}
 
 	stringArgs := make([]string, len(args))
+	length := len(separator) * (len(args) - 1)
 	for i := range args {
 		stringArgs[i] = fmt.Sprint(args[i])
+		length += len(stringArgs[i])
+	}
+	if err := env.CheckStringLength(length); err != nil {
+		return nil, err
 	}
 
 	return strings.Join(stringArgs, separator), nil
@@ -359,6 +1216,202 @@ func IsTruthy(value interface{}) bool {
 	return true
 }
 
+// Conversion evaluates its argument and inspects or converts the resulting value.
+type Conversion struct {
+	Name      string
+	Value     jql.Expression
+	Operation func(env jql.Environment, value interface{}) (interface{}, error)
+}
+
+func newConversion(name string, operation func(env jql.Environment, value interface{}) (interface{}, error), ts []jql.Expression) (jql.Expression, error) {
+	if len(ts) != 1 {
+		return nil, fmt.Errorf("invalid argument count to %s function: %v", name, len(ts))
+	}
+
+	return &Conversion{
+		Name:      name,
+		Value:     ts[0],
+		Operation: operation,
+	}, nil
+}
+
+func (t Conversion) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	value, err := t.Value.Get(env, arg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't evaluate %s argument: %w", t.Name, err)
+	}
+	out, err := t.Operation(env, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", t.Name, err)
+	}
+	return out, nil
+}
+
+func NewType(ts ...jql.Expression) (jql.Expression, error) {
+	return newConversion("type", func(env jql.Environment, value interface{}) (interface{}, error) {
+		return TypeName(value)
+	}, ts)
+}
+
+func newTypePredicate(name string, valueType jql.Type, ts []jql.Expression) (jql.Expression, error) {
+	return newConversion(name, func(env jql.Environment, value interface{}) (interface{}, error) {
+		return jql.TypeOf(value) == valueType, nil
+	}, ts)
+}
+
+func NewIsNull(ts ...jql.Expression) (jql.Expression, error) {
+	return newTypePredicate("isnull", jql.TypeNull, ts)
+}
+
+func NewIsBoolean(ts ...jql.Expression) (jql.Expression, error) {
+	return newTypePredicate("isboolean", jql.TypeBool, ts)
+}
+
+func NewIsNumber(ts ...jql.Expression) (jql.Expression, error) {
+	return newTypePredicate("isnumber", jql.TypeNumber, ts)
+}
+
+func NewIsString(ts ...jql.Expression) (jql.Expression, error) {
+	return newTypePredicate("isstring", jql.TypeString, ts)
+}
+
+func NewIsArray(ts ...jql.Expression) (jql.Expression, error) {
+	return newTypePredicate("isarray", jql.TypeArray, ts)
+}
+
+func NewIsObject(ts ...jql.Expression) (jql.Expression, error) {
+	return newTypePredicate("isobject", jql.TypeObject, ts)
+}
+
+// NewToString returns strings as they are, and the JSON encoding of any other value.
+func NewToString(ts ...jql.Expression) (jql.Expression, error) {
+	return newConversion("tostring", func(env jql.Environment, value interface{}) (interface{}, error) {
+		if str, ok := value.(string); ok {
+			return str, nil
+		}
+		return Stringify(env, value)
+	}, ts)
+}
+
+// NewToBool parses strings like "true" or "0", and converts any other value using the truthiness of IsTruthy.
+func NewToBool(ts ...jql.Expression) (jql.Expression, error) {
+	return newConversion("tobool", func(env jql.Environment, value interface{}) (interface{}, error) {
+		str, ok := value.(string)
+		if !ok {
+			return IsTruthy(value), nil
+		}
+		out, err := strconv.ParseBool(strings.TrimSpace(str))
+		if err != nil {
+			return nil, fmt.Errorf("can't convert string %q to bool", str)
+		}
+		return out, nil
+	}, ts)
+}
+
+// NewToJSON serializes the value to its JSON encoding.
+func NewToJSON(ts ...jql.Expression) (jql.Expression, error) {
+	return newConversion("tojson", func(env jql.Environment, value interface{}) (interface{}, error) {
+		return Stringify(env, value)
+	}, ts)
+}
+
+// NewFromJSON parses the JSON document in the string. Numbers are parsed exactly.
+func NewFromJSON(ts ...jql.Expression) (jql.Expression, error) {
+	return newConversion("fromjson", func(env jql.Environment, value interface{}) (interface{}, error) {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("can only parse strings, got %v of type %s", value, reflect.TypeOf(value))
+		}
+		decoder := json.NewDecoder(strings.NewReader(str))
+		decoder.UseNumber()
+		var out interface{}
+		if err := decoder.Decode(&out); err != nil {
+			return nil, fmt.Errorf("couldn't parse JSON: %w", err)
+		}
+		if _, err := decoder.Token(); err != io.EOF {
+			return nil, fmt.Errorf("couldn't parse JSON: unexpected data after the document")
+		}
+		if err := env.ChargeElements(countElements(out)); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}, ts)
+}
+
+// countElements returns the total number of elements in the arrays and objects of a value.
+func countElements(value interface{}) int {
+	count := 0
+	switch typed := value.(type) {
+	case []interface{}:
+		count += len(typed)
+		for i := range typed {
+			count += countElements(typed[i])
+		}
+	case map[string]interface{}:
+		count += len(typed)
+		for key := range typed {
+			count += countElements(typed[key])
+		}
+	}
+	return count
+}
+
+// ToNumber returns numbers as they are and parses strings holding numbers.
+// If the value can't be converted, it returns the default if there is one, or fails otherwise.
+type ToNumber struct {
+	Value   jql.Expression
+	Default jql.Expression
+}
+
+func NewToNumber(ts ...jql.Expression) (jql.Expression, error) {
+	switch len(ts) {
+	case 1:
+		return &ToNumber{
+			Value: ts[0],
+		}, nil
+	case 2:
+		return &ToNumber{
+			Value:   ts[0],
+			Default: ts[1],
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid argument count to tonumber function: %v", len(ts))
+	}
+}
+
+func (t ToNumber) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	value, err := t.Value.Get(env, arg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't evaluate tonumber argument: %w", err)
+	}
+	out, err := Numberify(value)
+	if err == nil {
+		return out, nil
+	}
+	if t.Default == nil {
+		return nil, fmt.Errorf("tonumber failed: %w", err)
+	}
+
+	out, err = t.Default.Get(env, arg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't evaluate tonumber default argument: %w", err)
+	}
+	return out, nil
+}
+
+// ApplyToElement evaluates the expression in the context of the element.
+// If the expression evaluates to a function, like (fn (x) ...), it's called with the element as its argument.
+func ApplyToElement(env jql.Environment, expression jql.Expression, element interface{}) (interface{}, error) {
+	value, err := expression.Get(env, element)
+	if err != nil {
+		return nil, err
+	}
+	if function, ok := value.(*jql.Closure); ok {
+		return function.Call(env, element, []interface{}{element})
+	}
+	return value, nil
+}
+
 type Filter struct {
 	Predicate  jql.Expression
 	Expression jql.Expression
@@ -381,7 +1434,7 @@ func NewFilter(ts ...jql.Expression) (jql.Expression, error) {
 	}
 }
 
-func (t Filter) Get(arg interface{}) (interface{}, error) {
+func (t Filter) Get(env jql.Environment, arg interface{}) (interface{}, error) {
 	args, ok := arg.([]interface{})
 	if !ok {
 		return nil, fmt.Errorf("filter expects an array, received %v of type %s", arg, reflect.TypeOf(arg))
@@ -390,19 +1443,86 @@ func (t Filter) Get(arg interface{}) (interface{}, error) {
 	out := make([]interface{}, 0, len(args))
 
 	for i := range args {
-		predicateValue, err := t.Predicate.Get(args[i])
+		if err := env.Step(); err != nil {
+			return nil, err
+		}
+		predicateValue, err := ApplyToElement(env, t.Predicate, args[i])
 		if err != nil {
 			return nil, fmt.Errorf("couldn't evaluate filter predicate for array index %d with expression value %v: %w", i, args[i], err)
 		}
 
-		if IsTruthy(predicateValue) {
-			out = append(out, args[i])
+		if !IsTruthy(predicateValue) {
+			continue
 		}
+		value, err := ApplyToElement(env, t.Expression, args[i])
+		if err != nil {
+			return nil, fmt.Errorf("couldn't evaluate filter expression for array index %d: %w", i, err)
+		}
+		if err := env.ChargeElements(1); err != nil {
+			return nil, err
+		}
+		out = append(out, value)
 	}
 
 	return out, nil
 }
 
+// Map evaluates the expression in the context of each array element or object value.
+type Map struct {
+	Expression jql.Expression
+}
+
+func NewMap(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) != 1 {
+		return nil, fmt.Errorf("invalid argument count to map function: %v", len(ts))
+	}
+
+	return Map{
+		Expression: ts[0],
+	}, nil
+}
+
+func (t Map) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	switch typed := arg.(type) {
+	case []interface{}:
+		if err := env.ChargeElements(len(typed)); err != nil {
+			return nil, err
+		}
+		out := make([]interface{}, len(typed))
+		for i := range typed {
+			if err := env.Step(); err != nil {
+				return nil, err
+			}
+			var err error
+			out[i], err = ApplyToElement(env, t.Expression, typed[i])
+			if err != nil {
+				return nil, fmt.Errorf("couldn't evaluate map expression for array index %d: %w", i, err)
+			}
+		}
+		return out, nil
+
+	case map[string]interface{}:
+		if err := env.ChargeElements(len(typed)); err != nil {
+			return nil, err
+		}
+		out := make(map[string]interface{}, len(typed))
+		for k := range typed {
+			if err := env.Step(); err != nil {
+				return nil, err
+			}
+			var err error
+			out[k], err = ApplyToElement(env, t.Expression, typed[k])
+			if err != nil {
+				return nil, fmt.Errorf("couldn't evaluate map expression for object field %s: %w", k, err)
+			}
+		}
+		return out, nil
+
+	default:
+		return nil, fmt.Errorf("map expects an array or object, received %v of type %s", arg, reflect.TypeOf(arg))
+	}
+}
+
 type Equal struct {
 	Left  jql.Expression
 	Right jql.Expression
@@ -419,17 +1539,151 @@ func NewEqual(ts ...jql.Expression) (jql.Expression, error) {
 	}, nil
 }
 
-func (t Equal) Get(arg interface{}) (interface{}, error) {
-	leftValue, err := t.Left.Get(arg)
+func (t Equal) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	leftValue, err := t.Left.Get(env, arg)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't evaluate equal function left expression: %w", err)
 	}
-	rightValue, err := t.Right.Get(arg)
+	rightValue, err := t.Right.Get(env, arg)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't evaluate equal function right expression: %w", err)
 	}
 
-	return reflect.DeepEqual(leftValue, rightValue), nil
+	return Equals(leftValue, rightValue), nil
+}
+
+// Equals compares two values structurally, treating numbers as equal when their values are,
+// regardless of whether they are represented as int, float64 or json.Number.
+func Equals(left, right interface{}) bool {
+	switch leftTyped := left.(type) {
+	case int, float64, json.Number:
+		cmp, err := CompareNumbers(left, right)
+		return err == nil && cmp == 0
+
+	case []interface{}:
+		rightTyped, ok := right.([]interface{})
+		if !ok || len(leftTyped) != len(rightTyped) {
+			return false
+		}
+		for i := range leftTyped {
+			if !Equals(leftTyped[i], rightTyped[i]) {
+				return false
+			}
+		}
+		return true
+
+	case map[string]interface{}:
+		rightTyped, ok := right.(map[string]interface{})
+		if !ok || len(leftTyped) != len(rightTyped) {
+			return false
+		}
+		for k := range leftTyped {
+			rightValue, ok := rightTyped[k]
+			if !ok || !Equals(leftTyped[k], rightValue) {
+				return false
+			}
+		}
+		return true
+
+	default:
+		return reflect.DeepEqual(left, right)
+	}
+}
+
+// typeOrder is the position of each type in the total order of values used by Compare.
+var typeOrder = map[jql.Type]int{
+	jql.TypeNull:   0,
+	jql.TypeBool:   1,
+	jql.TypeNumber: 2,
+	jql.TypeString: 3,
+	jql.TypeArray:  4,
+	jql.TypeObject: 5,
+}
+
+// Compare returns -1, 0 or 1 depending on whether left is less than, equal to or greater than right.
+// Values of different types are ordered null < bool < number < string < array < object.
+// Arrays are compared element by element, objects by their sorted keys first and then by the values under them.
+func Compare(left, right interface{}) (int, error) {
+	leftOrder, ok := typeOrder[jql.TypeOf(left)]
+	if !ok {
+		return 0, fmt.Errorf("can't compare value %v of type %s", left, reflect.TypeOf(left))
+	}
+	rightOrder, ok := typeOrder[jql.TypeOf(right)]
+	if !ok {
+		return 0, fmt.Errorf("can't compare value %v of type %s", right, reflect.TypeOf(right))
+	}
+	if leftOrder != rightOrder {
+		return compareInts(leftOrder, rightOrder), nil
+	}
+
+	switch leftTyped := left.(type) {
+	case nil:
+		return 0, nil
+
+	case bool:
+		rightTyped := right.(bool)
+		switch {
+		case leftTyped == rightTyped:
+			return 0, nil
+		case !leftTyped:
+			return -1, nil
+		default:
+			return 1, nil
+		}
+
+	case string:
+		return strings.Compare(leftTyped, right.(string)), nil
+
+	case []interface{}:
+		rightTyped := right.([]interface{})
+		for i := 0; i < len(leftTyped) && i < len(rightTyped); i++ {
+			cmp, err := Compare(leftTyped[i], rightTyped[i])
+			if err != nil || cmp != 0 {
+				return cmp, err
+			}
+		}
+		return compareInts(len(leftTyped), len(rightTyped)), nil
+
+	case map[string]interface{}:
+		rightTyped := right.(map[string]interface{})
+		leftKeys, rightKeys := sortedKeys(leftTyped), sortedKeys(rightTyped)
+		cmp, err := Compare(leftKeys, rightKeys)
+		if err != nil || cmp != 0 {
+			return cmp, err
+		}
+		for _, key := range leftKeys {
+			cmp, err := Compare(leftTyped[key.(string)], rightTyped[key.(string)])
+			if err != nil || cmp != 0 {
+				return cmp, err
+			}
+		}
+		return 0, nil
+
+	default:
+		return CompareNumbers(left, right)
+	}
+}
+
+func compareInts(left, right int) int {
+	switch {
+	case left < right:
+		return -1
+	case left > right:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func sortedKeys(object map[string]interface{}) []interface{} {
+	keys := make([]interface{}, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].(string) < keys[j].(string)
+	})
+	return keys
 }
 
 func Floatify(arg interface{}) (float64, error) {
@@ -438,11 +1692,214 @@ func Floatify(arg interface{}) (float64, error) {
 		return typed, nil
 	case int:
 		return float64(typed), nil
+	case json.Number:
+		out, err := typed.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("can't floatify number %v: %w", arg, err)
+		}
+		return out, nil
 	default:
 		return 0, fmt.Errorf("can't floatify value %v of type %s", arg, reflect.TypeOf(arg))
 	}
 }
 
+// Numberify returns numbers as they are and parses strings holding JSON numbers, surrounding whitespace aside.
+// Parsed numbers are exact.
+func Numberify(arg interface{}) (interface{}, error) {
+	switch typed := arg.(type) {
+	case int, float64, json.Number:
+		return typed, nil
+	case string:
+		trimmed := strings.TrimSpace(typed)
+		decoder := json.NewDecoder(strings.NewReader(trimmed))
+		decoder.UseNumber()
+		token, err := decoder.Token()
+		if number, ok := token.(json.Number); ok && err == nil {
+			if _, err := decoder.Token(); err == io.EOF {
+				return number, nil
+			}
+		}
+		return nil, fmt.Errorf("can't convert string %q to number", typed)
+	default:
+		return nil, fmt.Errorf("can't convert value %v of type %s to number", arg, reflect.TypeOf(arg))
+	}
+}
+
+// Stringify returns the JSON encoding of the value.
+func Stringify(env jql.Environment, arg interface{}) (string, error) {
+	if valueType := jql.TypeOf(arg); valueType == 0 || valueType == jql.TypeFunction {
+		return "", fmt.Errorf("can't serialize value %v of type %s", arg, reflect.TypeOf(arg))
+	}
+	data, err := json.Marshal(arg)
+	if err != nil {
+		return "", fmt.Errorf("can't serialize value %v: %w", arg, err)
+	}
+	if err := env.CheckStringLength(len(data)); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// TypeName returns the name of the type of the value, like "number" or "object".
+func TypeName(arg interface{}) (string, error) {
+	switch jql.TypeOf(arg) {
+	case jql.TypeNull:
+		return "null", nil
+	case jql.TypeBool:
+		return "boolean", nil
+	case jql.TypeNumber:
+		return "number", nil
+	case jql.TypeString:
+		return "string", nil
+	case jql.TypeArray:
+		return "array", nil
+	case jql.TypeObject:
+		return "object", nil
+	case jql.TypeFunction:
+		return "function", nil
+	default:
+		return "", fmt.Errorf("unknown type %s of value %v", reflect.TypeOf(arg), arg)
+	}
+}
+
+// CompareNumbers returns -1, 0 or 1 depending on whether left is less than, equal to or greater than right.
+// json.Number values are compared exactly, so integers above 2^53 don't lose precision.
+func CompareNumbers(left, right interface{}) (int, error) {
+	leftNumber, leftIsJSONNumber := left.(json.Number)
+	rightNumber, rightIsJSONNumber := right.(json.Number)
+	if !leftIsJSONNumber && !rightIsJSONNumber {
+		leftFloat, err := Floatify(left)
+		if err != nil {
+			return 0, err
+		}
+		rightFloat, err := Floatify(right)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case leftFloat < rightFloat:
+			return -1, nil
+		case leftFloat > rightFloat:
+			return 1, nil
+		default:
+			return 0, nil
+		}
+	}
+
+	if leftIsJSONNumber && rightIsJSONNumber {
+		leftInt, leftErr := strconv.ParseInt(leftNumber.String(), 10, 64)
+		rightInt, rightErr := strconv.ParseInt(rightNumber.String(), 10, 64)
+		if leftErr == nil && rightErr == nil {
+			switch {
+			case leftInt < rightInt:
+				return -1, nil
+			case leftInt > rightInt:
+				return 1, nil
+			default:
+				return 0, nil
+			}
+		}
+	}
+
+	leftRat, err := Ratify(left)
+	if err != nil {
+		return 0, err
+	}
+	rightRat, err := Ratify(right)
+	if err != nil {
+		return 0, err
+	}
+	return leftRat.Cmp(rightRat), nil
+}
+
+// Ratify converts a number to its exact rational representation.
+func Ratify(arg interface{}) (*big.Rat, error) {
+	switch typed := arg.(type) {
+	case int:
+		return new(big.Rat).SetInt64(int64(typed)), nil
+	case float64:
+		out := new(big.Rat).SetFloat64(typed)
+		if out == nil {
+			return nil, fmt.Errorf("can't ratify non-finite number %v", typed)
+		}
+		return out, nil
+	case json.Number:
+		out, ok := new(big.Rat).SetString(typed.String())
+		if !ok {
+			return nil, fmt.Errorf("can't ratify invalid number %v", typed)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("can't ratify value %v of type %s", arg, reflect.TypeOf(arg))
+	}
+}
+
+// exactNumber formats a json.Number with the precision of its textual representation,
+// using an integer or floating point formatting depending on the verb.
+type exactNumber json.Number
+
+func (n exactNumber) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'd', 'b', 'o', 'x', 'X':
+		if r, ok := new(big.Rat).SetString(string(n)); ok && r.IsInt() {
+			r.Num().Format(f, verb)
+			return
+		}
+		// Numbers with a fractional part are formatted like floats, not like the string holding them.
+		if float, err := json.Number(n).Float64(); err == nil {
+			fmt.Fprintf(f, formatDirective(f, verb), float)
+			return
+		}
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		if float, _, err := big.ParseFloat(string(n), 10, uint(64+4*len(n)), big.ToNearestEven); err == nil {
+			float.Format(f, verb)
+			return
+		}
+	}
+
+	fmt.Fprintf(f, formatDirective(f, verb), string(n))
+}
+
+// formatDirective returns the directive with the flags, width and precision of the given formatting state, like %-8.3f.
+func formatDirective(f fmt.State, verb rune) string {
+	format := []byte{'%'}
+	for _, flag := range "+-# 0" {
+		if f.Flag(int(flag)) {
+			format = append(format, byte(flag))
+		}
+	}
+	if width, ok := f.Width(); ok {
+		format = strconv.AppendInt(format, int64(width), 10)
+	}
+	if precision, ok := f.Precision(); ok {
+		format = append(format, '.')
+		format = strconv.AppendInt(format, int64(precision), 10)
+	}
+	format = append(format, string(verb)...)
+	return string(format)
+}
+
+// Intify converts a number with no fractional part to an int.
+func Intify(arg interface{}) (int, error) {
+	switch typed := arg.(type) {
+	case int:
+		return typed, nil
+	case json.Number:
+		if out, err := strconv.Atoi(typed.String()); err == nil {
+			return out, nil
+		}
+	}
+
+	float, err := Floatify(arg)
+	if err != nil {
+		return 0, fmt.Errorf("can't intify value %v of type %s", arg, reflect.TypeOf(arg))
+	}
+	if float != math.Trunc(float) || float < math.MinInt64 || float >= math.MaxInt64 {
+		return 0, fmt.Errorf("can't intify value %v of type %s, should be an integer", arg, reflect.TypeOf(arg))
+	}
+	return int(float), nil
+}
+
 type LessThan struct {
 	Left  jql.Expression
 	Right jql.Expression
@@ -459,38 +1916,21 @@ func NewLessThan(ts ...jql.Expression) (jql.Expression, error) {
 	}, nil
 }
 
-func (t LessThan) Get(arg interface{}) (interface{}, error) {
-	leftValue, err := t.Left.Get(arg)
+func (t LessThan) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	leftValue, err := t.Left.Get(env, arg)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't evaluate lt function left expression: %w", err)
 	}
-	rightValue, err := t.Right.Get(arg)
+	rightValue, err := t.Right.Get(env, arg)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't evaluate lt function right expression: %w", err)
 	}
 
-	switch left := leftValue.(type) {
-	case string:
-		right, ok := rightValue.(string)
-		if !ok {
-			return false, nil
-		}
-		return left < right, nil
-
-	case int, float64:
-		leftFloat, err := Floatify(leftValue)
-		if err != nil {
-			return false, fmt.Errorf("can't floatify gt function left expression: %w", err)
-		}
-		rightFloat, err := Floatify(rightValue)
-		if err != nil {
-			return false, fmt.Errorf("can't floatify gt function right expression: %w", err)
-		}
-		return leftFloat < rightFloat, nil
-
-	default:
-		return false, nil
+	cmp, err := Compare(leftValue, rightValue)
+	if err != nil {
+		return false, fmt.Errorf("can't compare lt function arguments: %w", err)
 	}
+	return cmp < 0, nil
 }
 
 type GreaterThan struct {
@@ -509,38 +1949,21 @@ func NewGreaterThan(ts ...jql.Expression) (jql.Expression, error) {
 	}, nil
 }
 
-func (t GreaterThan) Get(arg interface{}) (interface{}, error) {
-	leftValue, err := t.Left.Get(arg)
+func (t GreaterThan) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	leftValue, err := t.Left.Get(env, arg)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't evaluate gt function left expression: %w", err)
 	}
-	rightValue, err := t.Right.Get(arg)
+	rightValue, err := t.Right.Get(env, arg)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't evaluate gt function right expression: %w", err)
 	}
 
-	switch left := leftValue.(type) {
-	case string:
-		right, ok := rightValue.(string)
-		if !ok {
-			return false, nil
-		}
-		return left > right, nil
-
-	case int, float64:
-		leftFloat, err := Floatify(leftValue)
-		if err != nil {
-			return false, fmt.Errorf("can't floatify gt function left expression: %w", err)
-		}
-		rightFloat, err := Floatify(rightValue)
-		if err != nil {
-			return false, fmt.Errorf("can't floatify gt function right expression: %w", err)
-		}
-		return leftFloat > rightFloat, nil
-
-	default:
-		return false, nil
+	cmp, err := Compare(leftValue, rightValue)
+	if err != nil {
+		return false, fmt.Errorf("can't compare gt function arguments: %w", err)
 	}
+	return cmp > 0, nil
 }
 
 type Range struct {
@@ -565,33 +1988,57 @@ func NewRange(ts ...jql.Expression) (jql.Expression, error) {
 	}
 }
 
-func (t Range) Get(arg interface{}) (interface{}, error) {
-	beginValue, err := t.Begin.Get(arg)
+func (t Range) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	beginValue, err := t.Begin.Get(env, arg)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't evaluate range function begin expression: %w", err)
 	}
-	begin, ok := beginValue.(int)
-	if !ok {
-		return nil, fmt.Errorf("range expected begin argument of type int, got %v of type %s", beginValue, reflect.TypeOf(beginValue))
+	begin, err := Intify(beginValue)
+	if err != nil {
+		return nil, fmt.Errorf("range expected integer begin argument: %w", err)
 	}
 
-	endValue, err := t.End.Get(arg)
+	endValue, err := t.End.Get(env, arg)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't evaluate range function end expression: %w", err)
 	}
-	end, ok := endValue.(int)
-	if !ok {
-		return nil, fmt.Errorf("range expected end argument of type int, got %v of type %s", endValue, reflect.TypeOf(endValue))
+	end, err := Intify(endValue)
+	if err != nil {
+		return nil, fmt.Errorf("range expected integer end argument: %w", err)
+	}
+	if end < begin {
+		end = begin
+	}
+	// end - begin can overflow an int, so the length is computed as an uint64 and clamped to still go over the limit.
+	length := jql.ElementsHardLimit + 1
+	if uint64(end)-uint64(begin) <= jql.ElementsHardLimit {
+		length = end - begin
+	}
+	if err := env.ChargeElements(length); err != nil {
+		return nil, err
 	}
 
-	out := make([]interface{}, end-begin)
-	for i := range out {
-		out[i] = begin + i
+	// The array grows as it's filled, so a cancelled evaluation stops before allocating all of it.
+	out := make([]interface{}, 0, clamp(length, 0, rangeChunkSize))
+	for i := 0; i < length; i++ {
+		if i%rangeChunkSize == 0 {
+			if err := env.Steps(clamp(length-i, 0, rangeChunkSize)); err != nil {
+				return nil, err
+			}
+		}
+		if len(out) == cap(out) {
+			if err := env.Err(); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, begin+i)
 	}
 
 	return out, nil
 }
 
+const rangeChunkSize = 1024
+
 type And struct {
 	Values []jql.Expression
 }
@@ -600,9 +2047,12 @@ func NewAnd(ts ...jql.Expression) (jql.Expression, error) {
 	return And{Values: ts}, nil
 }
 
-func (t And) Get(arg interface{}) (interface{}, error) {
+func (t And) Get(env jql.Environment, arg interface{}) (interface{}, error) {
 	for i := range t.Values {
-		v, err := t.Values[i].Get(arg)
+		if err := env.Step(); err != nil {
+			return nil, err
+		}
+		v, err := t.Values[i].Get(env, arg)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't evaluate and argument with index %d: %w", i, err)
 		}
@@ -622,9 +2072,12 @@ func NewOr(ts ...jql.Expression) (jql.Expression, error) {
 	return Or{Values: ts}, nil
 }
 
-func (t Or) Get(arg interface{}) (interface{}, error) {
+func (t Or) Get(env jql.Environment, arg interface{}) (interface{}, error) {
 	for i := range t.Values {
-		v, err := t.Values[i].Get(arg)
+		if err := env.Step(); err != nil {
+			return nil, err
+		}
+		v, err := t.Values[i].Get(env, arg)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't evaluate or argument with index %d: %w", i, err)
 		}
@@ -647,8 +2100,8 @@ func NewNot(ts ...jql.Expression) (jql.Expression, error) {
 	return Not{Value: ts[0]}, nil
 }
 
-func (t Not) Get(arg interface{}) (interface{}, error) {
-	v, err := t.Value.Get(arg)
+func (t Not) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	v, err := t.Value.Get(env, arg)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't evaluate not argument with index: %w", err)
 	}
@@ -673,20 +2126,20 @@ func NewIfTE(ts ...jql.Expression) (jql.Expression, error) {
 	}, nil
 }
 
-func (t IfTE) Get(arg interface{}) (interface{}, error) {
-	ifExpression, err := t.If.Get(arg)
+func (t IfTE) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	ifExpression, err := t.If.Get(env, arg)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't evaluate ifte function if expression: %w", err)
 	}
 
 	if IsTruthy(ifExpression) {
-		thenExpression, err := t.Then.Get(arg)
+		thenExpression, err := t.Then.Get(env, arg)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't evaluate ifte function then expression: %w", err)
 		}
 		return thenExpression, nil
 	} else {
-		elseExpression, err := t.Else.Get(arg)
+		elseExpression, err := t.Else.Get(env, arg)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't evaluate ifte function else expression: %w", err)
 		}
@@ -707,8 +2160,8 @@ func NewError(ts ...jql.Expression) (jql.Expression, error) {
 	}, nil
 }
 
-func (t Error) Get(arg interface{}) (interface{}, error) {
-	message, err := t.Message.Get(arg)
+func (t Error) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	message, err := t.Message.Get(env, arg)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't evaluate error function message expression: %w", err)
 	}
@@ -729,15 +2182,22 @@ func NewRecover(ts ...jql.Expression) (jql.Expression, error) {
 	}, nil
 }
 
-func (t Recover) Get(arg interface{}) (out interface{}, err error) {
+func (t Recover) Get(env jql.Environment, arg interface{}) (out interface{}, err error) {
 	defer func() {
 		if err := recover(); err != nil {
 			out = nil
 			err = nil
 		}
 	}()
-	value, err := t.Expression.Get(arg)
+	value, err := t.Expression.Get(env, arg)
 	if err != nil {
+		if ctxErr := env.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		var limitErr *jql.LimitError
+		if errors.As(err, &limitErr) {
+			return nil, err
+		}
 		return nil, nil
 	}
 
@@ -749,13 +2209,20 @@ type Zip struct {
 }
 
 func NewZip(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) == 0 {
+		return nil, fmt.Errorf("invalid argument count to zip function: %v", len(ts))
+	}
+
 	return Zip{Arguments: ts}, nil
 }
 
-func (t Zip) Get(arg interface{}) (interface{}, error) {
+func (t Zip) Get(env jql.Environment, arg interface{}) (interface{}, error) {
 	args := make([][]interface{}, len(t.Arguments))
 	for i, curArg := range t.Arguments {
-		curArgValue, err := curArg.Get(arg)
+		if err := env.Step(); err != nil {
+			return nil, err
+		}
+		curArgValue, err := curArg.Get(env, arg)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't evaluate argument with index %d: %w", i, err)
 		}
@@ -770,13 +2237,55 @@ func (t Zip) Get(arg interface{}) (interface{}, error) {
 	var out []interface{}
 
 	for i := 0; ; i++ {
-		curOut := make([]interface{}, len(args))
+		if err := env.Step(); err != nil {
+			return nil, err
+		}
 		for j := range args {
 			if i >= len(args[j]) {
 				return out, nil
 			}
+		}
+		// Each row is an element of the result, holding an element of each argument.
+		if err := env.ChargeElements(len(args) + 1); err != nil {
+			return nil, err
+		}
+		curOut := make([]interface{}, len(args))
+		for j := range args {
 			curOut[j] = args[j][i]
 		}
 		out = append(out, curOut)
 	}
 }
+
+type Call struct {
+	Function  jql.Expression
+	Arguments []jql.Expression
+}
+
+func NewCall(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) == 0 {
+		return nil, fmt.Errorf("call function needs at least one argument")
+	}
+	return Call{Function: ts[0], Arguments: ts[1:]}, nil
+}
+
+func (t Call) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	functionValue, err := t.Function.Get(env, arg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't evaluate call function argument: %w", err)
+	}
+	function, ok := functionValue.(*jql.Closure)
+	if !ok {
+		return nil, fmt.Errorf("call expects a function as its first argument, received %v of type %s", functionValue, reflect.TypeOf(functionValue))
+	}
+
+	args := make([]interface{}, len(t.Arguments))
+	for i := range t.Arguments {
+		args[i], err = t.Arguments[i].Get(env, arg)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't evaluate call argument with index %d: %w", i, err)
+		}
+	}
+
+	return function.Call(env, arg, args)
+}
@@ -0,0 +1,148 @@
+package functions
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/cube2222/jql/jql"
+)
+
+// GroupBy groups the elements of the array in the current context by the key, evaluated in the context of each element.
+// The value expression, if given, is evaluated in the context of each group's array of elements.
+// Groups are returned as an object keyed by the stringified keys, or as an array of {"key": ..., "values": ...} objects, sorted by key.
+type GroupBy struct {
+	Key     jql.Expression
+	Value   jql.Expression
+	Entries bool
+}
+
+func newGroupBy(name string, entries bool, ts []jql.Expression) (jql.Expression, error) {
+	switch len(ts) {
+	case 1:
+		return GroupBy{
+			Key:     ts[0],
+			Value:   Identity{},
+			Entries: entries,
+		}, nil
+	case 2:
+		return GroupBy{
+			Key:     ts[0],
+			Value:   ts[1],
+			Entries: entries,
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid argument count to %s function: %v", name, len(ts))
+	}
+}
+
+func NewGroupBy(ts ...jql.Expression) (jql.Expression, error) {
+	return newGroupBy("groupby", false, ts)
+}
+
+func NewGroupEntries(ts ...jql.Expression) (jql.Expression, error) {
+	return newGroupBy("groupentries", true, ts)
+}
+
+func (t GroupBy) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	name := "groupby"
+	if t.Entries {
+		name = "groupentries"
+	}
+	array, ok := arg.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("can only use %s on array, used on: %s", name, reflect.TypeOf(arg))
+	}
+
+	keys := make([][]interface{}, len(array))
+	for i := range array {
+		if err := env.Step(); err != nil {
+			return nil, err
+		}
+		key, err := ApplyToElement(env, t.Key, array[i])
+		if err != nil {
+			return nil, fmt.Errorf("couldn't evaluate %s key for element with index %d: %w", name, i, err)
+		}
+		keys[i] = []interface{}{key}
+	}
+
+	indices, err := sortedIndices(env, keys, []bool{false})
+	if err != nil {
+		return nil, err
+	}
+	// Every element ends up in the array of its group.
+	if err := env.ChargeElements(len(array)); err != nil {
+		return nil, err
+	}
+
+	var groupKeys []interface{}
+	var groups [][]interface{}
+	for _, index := range indices {
+		key := keys[index][0]
+		if len(groupKeys) > 0 {
+			cmp, err := Compare(groupKeys[len(groupKeys)-1], key)
+			if err != nil {
+				return nil, err
+			}
+			if cmp == 0 {
+				groups[len(groups)-1] = append(groups[len(groups)-1], array[index])
+				continue
+			}
+		}
+		groupKeys = append(groupKeys, key)
+		groups = append(groups, []interface{}{array[index]})
+	}
+
+	if t.Entries {
+		// Each entry is an element of the result, holding a key and values.
+		if err := env.ChargeElements(3 * len(groups)); err != nil {
+			return nil, err
+		}
+		out := make([]interface{}, len(groups))
+		for i := range groups {
+			values, err := t.groupValue(env, name, groupKeys[i], groups[i])
+			if err != nil {
+				return nil, err
+			}
+			out[i] = map[string]interface{}{
+				"key":    groupKeys[i],
+				"values": values,
+			}
+		}
+		return out, nil
+	}
+
+	// Different keys can have the same string form, like 1 and "1", in which case their groups are merged.
+	merged := make(map[string][]interface{}, len(groups))
+	for i := range groups {
+		key, ok := groupKeys[i].(string)
+		if !ok {
+			key, err = Stringify(env, groupKeys[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s key: %w", name, err)
+			}
+		}
+		merged[key] = append(merged[key], groups[i]...)
+	}
+	if err := env.ChargeElements(len(merged)); err != nil {
+		return nil, err
+	}
+	out := make(map[string]interface{}, len(merged))
+	for key, group := range merged {
+		out[key], err = t.groupValue(env, name, key, group)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (t GroupBy) groupValue(env jql.Environment, name string, key interface{}, group []interface{}) (interface{}, error) {
+	if err := env.Step(); err != nil {
+		return nil, err
+	}
+	value, err := ApplyToElement(env, t.Value, group)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't evaluate %s value for group with key %v: %w", name, key, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,398 @@
+package functions
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+
+	"github.com/cube2222/jql/jql"
+)
+
+// Arithmetic folds its numeric arguments from left to right using a binary operation.
+// Integers stay integers as long as the result is one, otherwise the operation is done on floats.
+type Arithmetic struct {
+	Name      string
+	Arguments []jql.Expression
+	Operation func(left, right interface{}) (interface{}, error)
+	// Unary is used instead of the operation when there's only a single argument, if it's not nil.
+	Unary func(value interface{}) (interface{}, error)
+}
+
+func newArithmetic(name string, minArgs int, operation func(left, right interface{}) (interface{}, error), unary func(value interface{}) (interface{}, error), ts []jql.Expression) (jql.Expression, error) {
+	if len(ts) < minArgs {
+		return nil, fmt.Errorf("invalid argument count to %s function: %v", name, len(ts))
+	}
+
+	return &Arithmetic{
+		Name:      name,
+		Arguments: ts,
+		Operation: operation,
+		Unary:     unary,
+	}, nil
+}
+
+func NewAdd(ts ...jql.Expression) (jql.Expression, error) {
+	return newArithmetic("add", 1, Add, nil, ts)
+}
+
+func NewSubtract(ts ...jql.Expression) (jql.Expression, error) {
+	return newArithmetic("sub", 1, Subtract, Negate, ts)
+}
+
+func NewMultiply(ts ...jql.Expression) (jql.Expression, error) {
+	return newArithmetic("mul", 1, Multiply, nil, ts)
+}
+
+func NewDivide(ts ...jql.Expression) (jql.Expression, error) {
+	return newArithmetic("div", 2, Divide, nil, ts)
+}
+
+func NewModulo(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) != 2 {
+		return nil, fmt.Errorf("invalid argument count to mod function: %v", len(ts))
+	}
+	return newArithmetic("mod", 2, Modulo, nil, ts)
+}
+
+func NewPower(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) != 2 {
+		return nil, fmt.Errorf("invalid argument count to pow function: %v", len(ts))
+	}
+	return newArithmetic("pow", 2, Power, nil, ts)
+}
+
+func (t Arithmetic) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	first, err := t.Arguments[0].Get(env, arg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't evaluate %s argument with index 0: %w", t.Name, err)
+	}
+	return t.apply(env, arg, first)
+}
+
+// apply evaluates the rest of the arguments and folds them, given the value of the first one.
+func (t Arithmetic) apply(env jql.Environment, arg interface{}, first interface{}) (interface{}, error) {
+	var out interface{}
+	for i := range t.Arguments {
+		if err := env.Step(); err != nil {
+			return nil, err
+		}
+		value := first
+		if i > 0 {
+			var err error
+			value, err = t.Arguments[i].Get(env, arg)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't evaluate %s argument with index %d: %w", t.Name, i, err)
+			}
+		}
+		if jql.TypeOf(value) != jql.TypeNumber {
+			return nil, fmt.Errorf("%s expects numbers, argument with index %d is %v of type %s", t.Name, i, value, reflect.TypeOf(value))
+		}
+
+		if i == 0 {
+			out = value
+			continue
+		}
+		var err error
+		out, err = t.Operation(out, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s failed at argument with index %d: %w", t.Name, i, err)
+		}
+	}
+
+	if len(t.Arguments) == 1 && t.Unary != nil {
+		var err error
+		out, err = t.Unary(out)
+		if err != nil {
+			return nil, fmt.Errorf("%s failed: %w", t.Name, err)
+		}
+	}
+
+	return out, nil
+}
+
+// MathFunction applies a numeric operation to its single argument.
+type MathFunction struct {
+	Name      string
+	Argument  jql.Expression
+	Operation func(value interface{}) (interface{}, error)
+}
+
+func newMathFunction(name string, operation func(value interface{}) (interface{}, error), ts []jql.Expression) (jql.Expression, error) {
+	if len(ts) != 1 {
+		return nil, fmt.Errorf("invalid argument count to %s function: %v", name, len(ts))
+	}
+
+	return &MathFunction{
+		Name:      name,
+		Argument:  ts[0],
+		Operation: operation,
+	}, nil
+}
+
+func NewNegate(ts ...jql.Expression) (jql.Expression, error) {
+	return newMathFunction("neg", Negate, ts)
+}
+
+func NewAbs(ts ...jql.Expression) (jql.Expression, error) {
+	return newMathFunction("abs", Abs, ts)
+}
+
+func NewFloor(ts ...jql.Expression) (jql.Expression, error) {
+	return newMathFunction("floor", Floor, ts)
+}
+
+func NewCeil(ts ...jql.Expression) (jql.Expression, error) {
+	return newMathFunction("ceil", Ceil, ts)
+}
+
+func NewRound(ts ...jql.Expression) (jql.Expression, error) {
+	return newMathFunction("round", Round, ts)
+}
+
+func NewSqrt(ts ...jql.Expression) (jql.Expression, error) {
+	return newMathFunction("sqrt", Sqrt, ts)
+}
+
+func (t MathFunction) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	value, err := t.Argument.Get(env, arg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't evaluate %s argument: %w", t.Name, err)
+	}
+	if jql.TypeOf(value) != jql.TypeNumber {
+		return nil, fmt.Errorf("%s expects a number, got %v of type %s", t.Name, value, reflect.TypeOf(value))
+	}
+
+	out, err := t.Operation(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", t.Name, err)
+	}
+	return out, nil
+}
+
+func Add(left, right interface{}) (interface{}, error) {
+	if leftInt, rightInt, ok := bigIntifyBoth(left, right); ok {
+		return numberFromBigInt(new(big.Int).Add(leftInt, rightInt)), nil
+	}
+	return floatOperation(left, right, func(left, right float64) float64 {
+		return left + right
+	})
+}
+
+func Subtract(left, right interface{}) (interface{}, error) {
+	if leftInt, rightInt, ok := bigIntifyBoth(left, right); ok {
+		return numberFromBigInt(new(big.Int).Sub(leftInt, rightInt)), nil
+	}
+	return floatOperation(left, right, func(left, right float64) float64 {
+		return left - right
+	})
+}
+
+func Multiply(left, right interface{}) (interface{}, error) {
+	if leftInt, rightInt, ok := bigIntifyBoth(left, right); ok {
+		return numberFromBigInt(new(big.Int).Mul(leftInt, rightInt)), nil
+	}
+	return floatOperation(left, right, func(left, right float64) float64 {
+		return left * right
+	})
+}
+
+// Divide returns an integer if both arguments are integers and the division is exact, a float otherwise.
+func Divide(left, right interface{}) (interface{}, error) {
+	if leftInt, rightInt, ok := bigIntifyBoth(left, right); ok {
+		if rightInt.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		quotient, remainder := new(big.Int).QuoRem(leftInt, rightInt, new(big.Int))
+		if remainder.Sign() == 0 {
+			return numberFromBigInt(quotient), nil
+		}
+		out, _ := new(big.Rat).SetFrac(leftInt, rightInt).Float64()
+		return numberFromFloat(out)
+	}
+
+	rightFloat, err := Floatify(right)
+	if err != nil {
+		return nil, err
+	}
+	if rightFloat == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	return floatOperation(left, right, func(left, right float64) float64 {
+		return left / right
+	})
+}
+
+// Modulo returns the remainder of the division, which has the sign of the dividend.
+func Modulo(left, right interface{}) (interface{}, error) {
+	if leftInt, rightInt, ok := bigIntifyBoth(left, right); ok {
+		if rightInt.Sign() == 0 {
+			return nil, fmt.Errorf("modulo by zero")
+		}
+		return numberFromBigInt(new(big.Int).Rem(leftInt, rightInt)), nil
+	}
+
+	rightFloat, err := Floatify(right)
+	if err != nil {
+		return nil, err
+	}
+	if rightFloat == 0 {
+		return nil, fmt.Errorf("modulo by zero")
+	}
+	return floatOperation(left, right, math.Mod)
+}
+
+// maxExactPowerBits bounds the size of integer powers, larger ones are computed on floats.
+const maxExactPowerBits = 4096
+
+func Power(left, right interface{}) (interface{}, error) {
+	if base, exponent, ok := bigIntifyBoth(left, right); ok && exponent.Sign() >= 0 && exponent.IsInt64() {
+		// The bound is checked by dividing, as multiplying the bit length by the exponent could overflow.
+		if bits := int64(base.BitLen()); bits == 0 || exponent.Int64() <= maxExactPowerBits/bits {
+			return numberFromBigInt(new(big.Int).Exp(base, exponent, nil)), nil
+		}
+	}
+	return floatOperation(left, right, math.Pow)
+}
+
+// Min returns the smaller of the arguments, as it was passed in.
+func Min(left, right interface{}) (interface{}, error) {
+	cmp, err := CompareNumbers(left, right)
+	if err != nil {
+		return nil, err
+	}
+	if cmp <= 0 {
+		return left, nil
+	}
+	return right, nil
+}
+
+// Max returns the larger of the arguments, as it was passed in.
+func Max(left, right interface{}) (interface{}, error) {
+	cmp, err := CompareNumbers(left, right)
+	if err != nil {
+		return nil, err
+	}
+	if cmp >= 0 {
+		return left, nil
+	}
+	return right, nil
+}
+
+func Negate(value interface{}) (interface{}, error) {
+	if integer, ok := bigIntify(value); ok {
+		return numberFromBigInt(integer.Neg(integer)), nil
+	}
+	float, err := Floatify(value)
+	if err != nil {
+		return nil, err
+	}
+	return -float, nil
+}
+
+func Abs(value interface{}) (interface{}, error) {
+	if integer, ok := bigIntify(value); ok {
+		return numberFromBigInt(integer.Abs(integer)), nil
+	}
+	float, err := Floatify(value)
+	if err != nil {
+		return nil, err
+	}
+	return math.Abs(float), nil
+}
+
+func Floor(value interface{}) (interface{}, error) {
+	return integerOperation(value, math.Floor)
+}
+
+func Ceil(value interface{}) (interface{}, error) {
+	return integerOperation(value, math.Ceil)
+}
+
+// Round rounds half away from zero.
+func Round(value interface{}) (interface{}, error) {
+	return integerOperation(value, math.Round)
+}
+
+func Sqrt(value interface{}) (interface{}, error) {
+	float, err := Floatify(value)
+	if err != nil {
+		return nil, err
+	}
+	if float < 0 {
+		return nil, fmt.Errorf("can't take the square root of negative number %v", value)
+	}
+	return numberFromFloat(math.Sqrt(float))
+}
+
+// bigIntify returns the value as a big integer, if it's an int or an integer json.Number.
+func bigIntify(value interface{}) (*big.Int, bool) {
+	switch typed := value.(type) {
+	case int:
+		return big.NewInt(int64(typed)), true
+	case json.Number:
+		return new(big.Int).SetString(typed.String(), 10)
+	default:
+		return nil, false
+	}
+}
+
+func bigIntifyBoth(left, right interface{}) (*big.Int, *big.Int, bool) {
+	leftInt, ok := bigIntify(left)
+	if !ok {
+		return nil, nil, false
+	}
+	rightInt, ok := bigIntify(right)
+	if !ok {
+		return nil, nil, false
+	}
+	return leftInt, rightInt, true
+}
+
+// numberFromBigInt returns an int if the value fits, otherwise an exact json.Number.
+func numberFromBigInt(value *big.Int) interface{} {
+	if value.IsInt64() && int64(int(value.Int64())) == value.Int64() {
+		return int(value.Int64())
+	}
+	return json.Number(value.String())
+}
+
+// numberFromFloat fails for results which can't be represented in JSON, like infinity.
+func numberFromFloat(value float64) (interface{}, error) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("result %v is not a finite number", value)
+	}
+	return value, nil
+}
+
+func floatOperation(left, right interface{}, operation func(left, right float64) float64) (interface{}, error) {
+	leftFloat, err := Floatify(left)
+	if err != nil {
+		return nil, err
+	}
+	rightFloat, err := Floatify(right)
+	if err != nil {
+		return nil, err
+	}
+	return numberFromFloat(operation(leftFloat, rightFloat))
+}
+
+// integerOperation applies a rounding function to a float, returning an integer. Integers, and floats too large to have a fractional part, are returned unchanged.
+func integerOperation(value interface{}, operation func(float64) float64) (interface{}, error) {
+	if _, ok := bigIntify(value); ok {
+		return value, nil
+	}
+	float, err := Floatify(value)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := numberFromFloat(float); err != nil {
+		return nil, err
+	}
+	// Floats this large are integers already, and converting them would make up digits they don't have.
+	if math.Abs(float) >= 1<<53 {
+		return float, nil
+	}
+	return numberFromBigInt(big.NewInt(int64(operation(float)))), nil
+}
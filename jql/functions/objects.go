@@ -0,0 +1,270 @@
+package functions
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/cube2222/jql/jql"
+)
+
+// ObjectFunction evaluates its arguments in the context of the object in the current context and applies an operation to them.
+// Operations never modify the object, they return a new one instead, as the input may be shared.
+type ObjectFunction struct {
+	Name      string
+	Arguments []jql.Expression
+	Operation func(env jql.Environment, object map[string]interface{}, args []interface{}) (interface{}, error)
+}
+
+func newObjectFunction(name string, minArgs int, pairs bool, operation func(env jql.Environment, object map[string]interface{}, args []interface{}) (interface{}, error), ts []jql.Expression) (jql.Expression, error) {
+	if len(ts) < minArgs || pairs && len(ts)%2 != 0 {
+		return nil, fmt.Errorf("invalid argument count to %s function: %v", name, len(ts))
+	}
+
+	return &ObjectFunction{
+		Name:      name,
+		Arguments: ts,
+		Operation: operation,
+	}, nil
+}
+
+func (t ObjectFunction) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	object, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("can only use %s on object, used on: %s", t.Name, reflect.TypeOf(arg))
+	}
+
+	values := make([]interface{}, len(t.Arguments))
+	for i := range t.Arguments {
+		if err := env.Step(); err != nil {
+			return nil, err
+		}
+		var err error
+		values[i], err = t.Arguments[i].Get(env, arg)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't evaluate %s argument with index %d: %w", t.Name, i, err)
+		}
+	}
+
+	return t.Operation(env, object, values)
+}
+
+func keyArgument(name string, i int, value interface{}) (string, error) {
+	key, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s key argument with index %d should be string, is %v of type %s", name, i, value, reflect.TypeOf(value))
+	}
+	return key, nil
+}
+
+// copyObject returns a shallow copy of the object, which the caller can modify.
+func copyObject(env jql.Environment, object map[string]interface{}) (map[string]interface{}, error) {
+	if err := env.ChargeElements(len(object)); err != nil {
+		return nil, err
+	}
+	out := make(map[string]interface{}, len(object))
+	for key, value := range object {
+		out[key] = value
+	}
+	return out, nil
+}
+
+func newSet(name string, ts []jql.Expression) (jql.Expression, error) {
+	return newObjectFunction(name, 2, true, func(env jql.Environment, object map[string]interface{}, args []interface{}) (interface{}, error) {
+		out, err := copyObject(env, object)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(args); i += 2 {
+			key, err := keyArgument(name, i, args[i])
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := out[key]; !ok {
+				if err := env.ChargeElements(1); err != nil {
+					return nil, err
+				}
+			}
+			out[key] = args[i+1]
+		}
+		return out, nil
+	}, ts)
+}
+
+// NewSet returns the object with the keys set to the values following them, which are evaluated in the context of the object.
+func NewSet(ts ...jql.Expression) (jql.Expression, error) {
+	return newSet("set", ts)
+}
+
+func NewAssoc(ts ...jql.Expression) (jql.Expression, error) {
+	return newSet("assoc", ts)
+}
+
+func newDelete(name string, ts []jql.Expression) (jql.Expression, error) {
+	return newObjectFunction(name, 1, false, func(env jql.Environment, object map[string]interface{}, args []interface{}) (interface{}, error) {
+		out, err := copyObject(env, object)
+		if err != nil {
+			return nil, err
+		}
+		for i := range args {
+			key, err := keyArgument(name, i, args[i])
+			if err != nil {
+				return nil, err
+			}
+			delete(out, key)
+		}
+		return out, nil
+	}, ts)
+}
+
+// NewDelete returns the object without the given keys.
+func NewDelete(ts ...jql.Expression) (jql.Expression, error) {
+	return newDelete("delete", ts)
+}
+
+func NewOmit(ts ...jql.Expression) (jql.Expression, error) {
+	return newDelete("omit", ts)
+}
+
+// NewPick returns an object with only the given keys of the object. Missing keys are skipped.
+func NewPick(ts ...jql.Expression) (jql.Expression, error) {
+	return newObjectFunction("pick", 1, false, func(env jql.Environment, object map[string]interface{}, args []interface{}) (interface{}, error) {
+		if err := env.ChargeElements(len(args)); err != nil {
+			return nil, err
+		}
+		out := make(map[string]interface{}, len(args))
+		for i := range args {
+			key, err := keyArgument("pick", i, args[i])
+			if err != nil {
+				return nil, err
+			}
+			if value, ok := object[key]; ok {
+				out[key] = value
+			}
+		}
+		return out, nil
+	}, ts)
+}
+
+// NewRename returns the object with each key in the arguments renamed to the key following it. Missing keys are skipped.
+// All keys are renamed at once, so (rename "a" "b" "b" "a") swaps two fields.
+func NewRename(ts ...jql.Expression) (jql.Expression, error) {
+	return newObjectFunction("rename", 2, true, func(env jql.Environment, object map[string]interface{}, args []interface{}) (interface{}, error) {
+		out, err := copyObject(env, object)
+		if err != nil {
+			return nil, err
+		}
+		renamed := make(map[string]interface{}, len(args)/2)
+		for i := 0; i < len(args); i += 2 {
+			from, err := keyArgument("rename", i, args[i])
+			if err != nil {
+				return nil, err
+			}
+			to, err := keyArgument("rename", i+1, args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			if value, ok := object[from]; ok {
+				delete(out, from)
+				renamed[to] = value
+			}
+		}
+		for key, value := range renamed {
+			out[key] = value
+		}
+		return out, nil
+	}, ts)
+}
+
+// NewHas returns whether the object has the given key.
+func NewHas(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) != 1 {
+		return nil, fmt.Errorf("invalid argument count to has function: %v", len(ts))
+	}
+	return newObjectFunction("has", 1, false, func(env jql.Environment, object map[string]interface{}, args []interface{}) (interface{}, error) {
+		key, err := keyArgument("has", 0, args[0])
+		if err != nil {
+			return nil, err
+		}
+		_, ok := object[key]
+		return ok, nil
+	}, ts)
+}
+
+// Merge returns an object with the fields of all the objects, where later objects override earlier ones.
+// A deep merge merges the values of fields which are objects in both, instead of overriding them.
+type Merge struct {
+	Objects []jql.Expression
+	Deep    bool
+}
+
+func newMerge(name string, deep bool, ts []jql.Expression) (jql.Expression, error) {
+	if len(ts) == 0 {
+		return nil, fmt.Errorf("invalid argument count to %s function: %v", name, len(ts))
+	}
+	return &Merge{
+		Objects: ts,
+		Deep:    deep,
+	}, nil
+}
+
+func NewMerge(ts ...jql.Expression) (jql.Expression, error) {
+	return newMerge("merge", false, ts)
+}
+
+func NewDeepMerge(ts ...jql.Expression) (jql.Expression, error) {
+	return newMerge("deepmerge", true, ts)
+}
+
+func (t Merge) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	name := "merge"
+	if t.Deep {
+		name = "deepmerge"
+	}
+
+	out := make(map[string]interface{})
+	for i := range t.Objects {
+		value, err := t.Objects[i].Get(env, arg)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't evaluate %s argument with index %d: %w", name, i, err)
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s expects objects, argument with index %d is %v of type %s", name, i, value, reflect.TypeOf(value))
+		}
+		if err := mergeInto(env, out, object, t.Deep); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// mergeInto sets the fields of the object in out, which has to be a map owned by the caller.
+func mergeInto(env jql.Environment, out, object map[string]interface{}, deep bool) error {
+	for key, value := range object {
+		if err := env.Step(); err != nil {
+			return err
+		}
+		if _, ok := out[key]; !ok {
+			if err := env.ChargeElements(1); err != nil {
+				return err
+			}
+		}
+		existing, existingIsObject := out[key].(map[string]interface{})
+		valueObject, valueIsObject := value.(map[string]interface{})
+		if !deep || !existingIsObject || !valueIsObject {
+			out[key] = value
+			continue
+		}
+
+		// The existing object may come from the input, so it's copied before merging into it.
+		merged, err := copyObject(env, existing)
+		if err != nil {
+			return err
+		}
+		if err := mergeInto(env, merged, valueObject, deep); err != nil {
+			return err
+		}
+		out[key] = merged
+	}
+	return nil
+}
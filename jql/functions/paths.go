@@ -0,0 +1,397 @@
+package functions
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/cube2222/jql/jql"
+)
+
+// A path is an array of object keys and array indices, like ["countries", 0, "name"], leading to a value in a document.
+// Functions which change the value at a path return a new document, copying only the arrays and objects along the path.
+
+// pathArgument checks the path and converts its indices to ints.
+func pathArgument(name string, value interface{}) ([]interface{}, error) {
+	path, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s path should be an array of strings and integers, is %v of type %s", name, value, reflect.TypeOf(value))
+	}
+
+	out := make([]interface{}, len(path))
+	for i := range path {
+		switch typed := path[i].(type) {
+		case string:
+			out[i] = typed
+		case int, float64, json.Number:
+			index, err := Intify(typed)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s path index at position %d: %w", name, i, err)
+			}
+			out[i] = index
+		default:
+			return nil, fmt.Errorf("%s path elements should be strings or integers, element with index %d is %v of type %s", name, i, path[i], reflect.TypeOf(path[i]))
+		}
+	}
+	return out, nil
+}
+
+func evaluatePath(env jql.Environment, name string, expression jql.Expression, arg interface{}) ([]interface{}, error) {
+	value, err := expression.Get(env, arg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't evaluate %s path argument: %w", name, err)
+	}
+	return pathArgument(name, value)
+}
+
+// GetPath returns the value at the path in the current context, or null if there's none.
+type GetPath struct {
+	Path jql.Expression
+}
+
+func NewGetPath(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) != 1 {
+		return nil, fmt.Errorf("invalid argument count to getpath function: %v", len(ts))
+	}
+	return GetPath{Path: ts[0]}, nil
+}
+
+func (t GetPath) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	path, err := evaluatePath(env, "getpath", t.Path, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	value := arg
+	for i := range path {
+		if err := env.Step(); err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, nil
+		}
+		switch position := path[i].(type) {
+		case string:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("can't use string path element with index %d on value %v of type %s, should be object", i, value, reflect.TypeOf(value))
+			}
+			value = object[position]
+		case int:
+			array, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("can't use integer path element with index %d on value %v of type %s, should be array", i, value, reflect.TypeOf(value))
+			}
+			if position < 0 || len(array) <= position {
+				return nil, nil
+			}
+			value = array[position]
+		}
+	}
+	return value, nil
+}
+
+// SetPath returns the current context with the value at the path replaced by the value argument, evaluated in the current context.
+// Missing objects and arrays along the path are created, and arrays are extended with nulls if needed.
+type SetPath struct {
+	Path  jql.Expression
+	Value jql.Expression
+}
+
+func NewSetPath(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) != 2 {
+		return nil, fmt.Errorf("invalid argument count to setpath function: %v", len(ts))
+	}
+	return SetPath{
+		Path:  ts[0],
+		Value: ts[1],
+	}, nil
+}
+
+func (t SetPath) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	path, err := evaluatePath(env, "setpath", t.Path, arg)
+	if err != nil {
+		return nil, err
+	}
+	value, err := t.Value.Get(env, arg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't evaluate setpath value argument: %w", err)
+	}
+	return setPath(env, arg, path, 0, value)
+}
+
+func setPath(env jql.Environment, document interface{}, path []interface{}, i int, value interface{}) (interface{}, error) {
+	if i == len(path) {
+		return value, nil
+	}
+	if err := env.Step(); err != nil {
+		return nil, err
+	}
+
+	switch position := path[i].(type) {
+	case string:
+		object, ok := document.(map[string]interface{})
+		if !ok && document != nil {
+			return nil, fmt.Errorf("can't use string path element with index %d on value %v of type %s, should be object", i, document, reflect.TypeOf(document))
+		}
+		child, err := setPath(env, object[position], path, i+1, value)
+		if err != nil {
+			return nil, err
+		}
+		out, err := copyObject(env, object)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := out[position]; !ok {
+			if err := env.ChargeElements(1); err != nil {
+				return nil, err
+			}
+		}
+		out[position] = child
+		return out, nil
+
+	default:
+		index := position.(int)
+		array, ok := document.([]interface{})
+		if !ok && document != nil {
+			return nil, fmt.Errorf("can't use integer path element with index %d on value %v of type %s, should be array", i, document, reflect.TypeOf(document))
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("setpath index can't be negative, path element with index %d is %d", i, index)
+		}
+		var current interface{}
+		if index < len(array) {
+			current = array[index]
+		}
+		child, err := setPath(env, current, path, i+1, value)
+		if err != nil {
+			return nil, err
+		}
+
+		length := len(array)
+		if index >= length {
+			// The index is clamped before adding one, so the length can't overflow and still goes over the limit.
+			length = clamp(index, 0, jql.ElementsHardLimit) + 1
+		}
+		if err := env.ChargeElements(length); err != nil {
+			return nil, err
+		}
+		out := make([]interface{}, length)
+		copy(out, array)
+		out[index] = child
+		return out, nil
+	}
+}
+
+// DeletePath returns the current context without the value at the path. Later array elements are shifted back.
+// Deleting a path which doesn't exist leaves the value as it is, deleting the empty path returns null.
+type DeletePath struct {
+	Path jql.Expression
+}
+
+func NewDeletePath(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) != 1 {
+		return nil, fmt.Errorf("invalid argument count to delpath function: %v", len(ts))
+	}
+	return DeletePath{Path: ts[0]}, nil
+}
+
+func (t DeletePath) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	path, err := evaluatePath(env, "delpath", t.Path, arg)
+	if err != nil {
+		return nil, err
+	}
+	if len(path) == 0 {
+		return nil, nil
+	}
+	return deletePath(env, arg, path, 0)
+}
+
+func deletePath(env jql.Environment, document interface{}, path []interface{}, i int) (interface{}, error) {
+	if err := env.Step(); err != nil {
+		return nil, err
+	}
+	if document == nil {
+		return nil, nil
+	}
+	last := i == len(path)-1
+
+	switch position := path[i].(type) {
+	case string:
+		object, ok := document.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("can't use string path element with index %d on value %v of type %s, should be object", i, document, reflect.TypeOf(document))
+		}
+		child, ok := object[position]
+		if !ok {
+			return object, nil
+		}
+		out, err := copyObject(env, object)
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			delete(out, position)
+			return out, nil
+		}
+		out[position], err = deletePath(env, child, path, i+1)
+		if err != nil {
+			return nil, err
+		}
+		return out, nil
+
+	default:
+		index := position.(int)
+		array, ok := document.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("can't use integer path element with index %d on value %v of type %s, should be array", i, document, reflect.TypeOf(document))
+		}
+		if index < 0 || len(array) <= index {
+			return array, nil
+		}
+		if err := env.ChargeElements(len(array)); err != nil {
+			return nil, err
+		}
+		if last {
+			out := make([]interface{}, 0, len(array)-1)
+			out = append(out, array[:index]...)
+			return append(out, array[index+1:]...), nil
+		}
+		child, err := deletePath(env, array[index], path, i+1)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]interface{}, len(array))
+		copy(out, array)
+		out[index] = child
+		return out, nil
+	}
+}
+
+// Paths returns the paths of all the leaves in the current context, which are the values other than non-empty arrays and objects.
+// If there's a predicate, only the paths of leaves for which it's truthy are returned.
+// Object keys are visited in sorted order.
+type Paths struct {
+	Predicate jql.Expression
+}
+
+func NewPaths(ts ...jql.Expression) (jql.Expression, error) {
+	switch len(ts) {
+	case 0:
+		return Paths{}, nil
+	case 1:
+		return Paths{Predicate: ts[0]}, nil
+	default:
+		return nil, fmt.Errorf("invalid argument count to paths function: %v", len(ts))
+	}
+}
+
+func (t Paths) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	out := []interface{}{}
+	if err := t.collect(env, arg, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (t Paths) collect(env jql.Environment, value interface{}, path []interface{}, out *[]interface{}) error {
+	if err := env.Step(); err != nil {
+		return err
+	}
+
+	switch typed := value.(type) {
+	case []interface{}:
+		if len(typed) > 0 {
+			for i := range typed {
+				if err := t.collect(env, typed[i], append(path, i), out); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case map[string]interface{}:
+		if len(typed) > 0 {
+			for _, key := range sortedKeys(typed) {
+				if err := t.collect(env, typed[key.(string)], append(path, key), out); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	if t.Predicate != nil {
+		keep, err := ApplyToElement(env, t.Predicate, value)
+		if err != nil {
+			return fmt.Errorf("couldn't evaluate paths predicate for path %v: %w", path, err)
+		}
+		if !IsTruthy(keep) {
+			return nil
+		}
+	}
+	if err := env.ChargeElements(1 + len(path)); err != nil {
+		return err
+	}
+	leafPath := make([]interface{}, len(path))
+	copy(leafPath, path)
+	*out = append(*out, leafPath)
+	return nil
+}
+
+// Walk applies the expression to every value in the current context, bottom-up,
+// so the expression sees arrays and objects whose elements have already been transformed.
+type Walk struct {
+	Expression jql.Expression
+}
+
+func NewWalk(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) != 1 {
+		return nil, fmt.Errorf("invalid argument count to walk function: %v", len(ts))
+	}
+	return Walk{Expression: ts[0]}, nil
+}
+
+func (t Walk) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	return t.walk(env, arg, nil)
+}
+
+func (t Walk) walk(env jql.Environment, value interface{}, path []interface{}) (interface{}, error) {
+	if err := env.Step(); err != nil {
+		return nil, err
+	}
+
+	switch typed := value.(type) {
+	case []interface{}:
+		if err := env.ChargeElements(len(typed)); err != nil {
+			return nil, err
+		}
+		array := make([]interface{}, len(typed))
+		for i := range typed {
+			var err error
+			array[i], err = t.walk(env, typed[i], append(path, i))
+			if err != nil {
+				return nil, err
+			}
+		}
+		value = array
+	case map[string]interface{}:
+		if err := env.ChargeElements(len(typed)); err != nil {
+			return nil, err
+		}
+		object := make(map[string]interface{}, len(typed))
+		for _, key := range sortedKeys(typed) {
+			var err error
+			object[key.(string)], err = t.walk(env, typed[key.(string)], append(path, key))
+			if err != nil {
+				return nil, err
+			}
+		}
+		value = object
+	}
+
+	out, err := ApplyToElement(env, t.Expression, value)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't evaluate walk expression for path %v: %w", path, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,184 @@
+package functions
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/cube2222/jql/jql"
+)
+
+// Regex evaluates its arguments and applies an operation to the string in the second one, using the pattern in the first one.
+// A constant pattern is compiled once, when the function is constructed.
+type Regex struct {
+	Name      string
+	Pattern   jql.Expression
+	Compiled  *regexp.Regexp
+	Arguments []jql.Expression
+	Operation func(env jql.Environment, re *regexp.Regexp, str string, args []interface{}) (interface{}, error)
+}
+
+func newRegex(name string, argCount int, operation func(env jql.Environment, re *regexp.Regexp, str string, args []interface{}) (interface{}, error), ts []jql.Expression) (jql.Expression, error) {
+	if len(ts) != argCount {
+		return nil, fmt.Errorf("invalid argument count to %s function: %v", name, len(ts))
+	}
+
+	out := &Regex{
+		Name:      name,
+		Pattern:   ts[0],
+		Arguments: ts[1:],
+		Operation: operation,
+	}
+	if constant, ok := ts[0].(*jql.Constant); ok {
+		re, err := compilePattern(name, constant.Value)
+		if err != nil {
+			return nil, err
+		}
+		out.Compiled = re
+	}
+	return out, nil
+}
+
+func compilePattern(name string, pattern interface{}) (*regexp.Regexp, error) {
+	str, err := stringArgument(name, 0, pattern)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(str)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s pattern: %w", name, err)
+	}
+	return re, nil
+}
+
+func (t Regex) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	re := t.Compiled
+	if re == nil {
+		pattern, err := t.Pattern.Get(env, arg)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't evaluate %s argument with index 0: %w", t.Name, err)
+		}
+		re, err = compilePattern(t.Name, pattern)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	values := make([]interface{}, len(t.Arguments))
+	for i := range t.Arguments {
+		var err error
+		values[i], err = t.Arguments[i].Get(env, arg)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't evaluate %s argument with index %d: %w", t.Name, i+1, err)
+		}
+	}
+	str, err := stringArgument(t.Name, 1, values[0])
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := t.Operation(env, re, str, values[1:])
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", t.Name, err)
+	}
+	return out, nil
+}
+
+// NewTest returns whether the pattern matches anywhere in the string.
+func NewTest(ts ...jql.Expression) (jql.Expression, error) {
+	return newRegex("test", 2, func(env jql.Environment, re *regexp.Regexp, str string, args []interface{}) (interface{}, error) {
+		return re.MatchString(str), nil
+	}, ts)
+}
+
+// NewMatch returns the first match of the pattern in the string as an object
+// with the whole match, the array of capture groups and the object of named capture groups, or null if there's no match.
+// Groups which didn't participate in the match are null.
+func NewMatch(ts ...jql.Expression) (jql.Expression, error) {
+	return newRegex("match", 2, func(env jql.Environment, re *regexp.Regexp, str string, args []interface{}) (interface{}, error) {
+		submatches := re.FindStringSubmatchIndex(str)
+		if submatches == nil {
+			return nil, nil
+		}
+
+		// The result holds the whole match, the groups and the named groups.
+		if err := env.ChargeElements(3 + 2*re.NumSubexp()); err != nil {
+			return nil, err
+		}
+		groups := make([]interface{}, re.NumSubexp())
+		named := make(map[string]interface{})
+		for i, name := range re.SubexpNames()[1:] {
+			groups[i] = submatch(str, submatches, i+1)
+			if name != "" {
+				named[name] = groups[i]
+			}
+		}
+		return map[string]interface{}{
+			"match":  submatch(str, submatches, 0),
+			"groups": groups,
+			"named":  named,
+		}, nil
+	}, ts)
+}
+
+// NewScan returns all the non-overlapping matches of the pattern in the string.
+// If the pattern has capture groups, each match is the array of its groups instead of the whole match.
+func NewScan(ts ...jql.Expression) (jql.Expression, error) {
+	return newRegex("scan", 2, func(env jql.Environment, re *regexp.Regexp, str string, args []interface{}) (interface{}, error) {
+		matches := re.FindAllStringSubmatchIndex(str, -1)
+		if err := env.ChargeElements(len(matches) * (1 + re.NumSubexp())); err != nil {
+			return nil, err
+		}
+
+		out := make([]interface{}, len(matches))
+		for i := range matches {
+			if err := env.Step(); err != nil {
+				return nil, err
+			}
+			if re.NumSubexp() == 0 {
+				out[i] = submatch(str, matches[i], 0)
+				continue
+			}
+			groups := make([]interface{}, re.NumSubexp())
+			for j := range groups {
+				groups[j] = submatch(str, matches[i], j+1)
+			}
+			out[i] = groups
+		}
+		return out, nil
+	}, ts)
+}
+
+// NewRegsub replaces all the matches of the pattern in the string with the replacement,
+// in which $1 or ${name} stand for the text of the corresponding capture group.
+func NewRegsub(ts ...jql.Expression) (jql.Expression, error) {
+	return newRegex("regsub", 3, func(env jql.Environment, re *regexp.Regexp, str string, args []interface{}) (interface{}, error) {
+		replacement, err := stringArgument("regsub", 2, args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		var out []byte
+		last := 0
+		for _, match := range re.FindAllStringSubmatchIndex(str, -1) {
+			if err := env.Step(); err != nil {
+				return nil, err
+			}
+			out = append(out, str[last:match[0]]...)
+			out = re.ExpandString(out, replacement, str, match)
+			last = match[1]
+			if err := env.CheckStringLength(len(out) + len(str) - last); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, str[last:]...)
+		return string(out), nil
+	}, ts)
+}
+
+// submatch returns the text of the capture group with the given index, or nil if it didn't participate in the match.
+func submatch(str string, indices []int, group int) interface{} {
+	if indices[2*group] < 0 {
+		return nil
+	}
+	return str[indices[2*group]:indices[2*group+1]]
+}
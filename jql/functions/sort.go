@@ -0,0 +1,155 @@
+package functions
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/cube2222/jql/jql"
+)
+
+// Sort sorts the array in the current context, using the total order of values defined by Compare.
+type Sort struct {
+}
+
+func NewSort(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) != 0 {
+		return nil, fmt.Errorf("invalid argument count to sort function: %v", len(ts))
+	}
+	return Sort{}, nil
+}
+
+func (t Sort) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	array, ok := arg.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("can only use sort on array, used on: %s", reflect.TypeOf(arg))
+	}
+
+	keys := make([][]interface{}, len(array))
+	for i := range array {
+		keys[i] = []interface{}{array[i]}
+	}
+	return sortByKeys(env, array, keys, []bool{false})
+}
+
+// Descending marks a sortby key as descending. Anywhere else, it just evaluates to its argument.
+type Descending struct {
+	Key jql.Expression
+}
+
+func NewDescending(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) != 1 {
+		return nil, fmt.Errorf("invalid argument count to desc function: %v", len(ts))
+	}
+	return Descending{Key: ts[0]}, nil
+}
+
+func (t Descending) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	return t.Key.Get(env, arg)
+}
+
+// SortBy stably sorts the array in the current context by the keys, each evaluated in the context of the element.
+// Later keys are only used to order elements for which all the earlier ones are equal.
+type SortBy struct {
+	Keys       []jql.Expression
+	Descending []bool
+}
+
+func NewSortBy(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) == 0 {
+		return nil, fmt.Errorf("invalid argument count to sortby function: %v", len(ts))
+	}
+
+	out := SortBy{
+		Keys:       make([]jql.Expression, len(ts)),
+		Descending: make([]bool, len(ts)),
+	}
+	for i := range ts {
+		if descending, ok := ts[i].(Descending); ok {
+			out.Keys[i] = descending.Key
+			out.Descending[i] = true
+		} else {
+			out.Keys[i] = ts[i]
+		}
+	}
+	return out, nil
+}
+
+func (t SortBy) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	array, ok := arg.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("can only use sortby on array, used on: %s", reflect.TypeOf(arg))
+	}
+
+	keys := make([][]interface{}, len(array))
+	for i := range array {
+		keys[i] = make([]interface{}, len(t.Keys))
+		for j := range t.Keys {
+			if err := env.Step(); err != nil {
+				return nil, err
+			}
+			var err error
+			keys[i][j], err = ApplyToElement(env, t.Keys[j], array[i])
+			if err != nil {
+				return nil, fmt.Errorf("couldn't evaluate sortby key with index %d for element with index %d: %w", j, i, err)
+			}
+		}
+	}
+
+	return sortByKeys(env, array, keys, t.Descending)
+}
+
+// sortByKeys returns a stably sorted copy of the array, where the element at each index is ordered by the keys at the same index.
+func sortByKeys(env jql.Environment, array []interface{}, keys [][]interface{}, descending []bool) ([]interface{}, error) {
+	indices, err := sortedIndices(env, keys, descending)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := env.ChargeElements(len(array)); err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, len(array))
+	for i := range indices {
+		out[i] = array[indices[i]]
+	}
+	return out, nil
+}
+
+// sortedIndices returns the indices of the keys, stably sorted by them.
+func sortedIndices(env jql.Environment, keys [][]interface{}, descending []bool) ([]int, error) {
+	indices := make([]int, len(keys))
+	for i := range indices {
+		indices[i] = i
+	}
+
+	var compareErr error
+	sort.SliceStable(indices, func(i, j int) bool {
+		if compareErr != nil {
+			return false
+		}
+		if err := env.Step(); err != nil {
+			compareErr = err
+			return false
+		}
+		left, right := keys[indices[i]], keys[indices[j]]
+		for k := range left {
+			cmp, err := Compare(left[k], right[k])
+			if err != nil {
+				compareErr = fmt.Errorf("couldn't compare key with index %d: %w", k, err)
+				return false
+			}
+			if descending[k] {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+	if compareErr != nil {
+		return nil, compareErr
+	}
+	return indices, nil
+}
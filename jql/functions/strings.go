@@ -0,0 +1,319 @@
+package functions
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cube2222/jql/jql"
+)
+
+// StringFunction evaluates its arguments and applies an operation to them. The first argument has to be a string.
+// All indices and lengths are counted in runes, not bytes.
+type StringFunction struct {
+	Name      string
+	Arguments []jql.Expression
+	Operation func(env jql.Environment, str string, args []interface{}) (interface{}, error)
+}
+
+func newStringFunction(name string, minArgs, maxArgs int, operation func(env jql.Environment, str string, args []interface{}) (interface{}, error), ts []jql.Expression) (jql.Expression, error) {
+	if len(ts) < minArgs || len(ts) > maxArgs {
+		return nil, fmt.Errorf("invalid argument count to %s function: %v", name, len(ts))
+	}
+
+	return &StringFunction{
+		Name:      name,
+		Arguments: ts,
+		Operation: operation,
+	}, nil
+}
+
+func (t StringFunction) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	values := make([]interface{}, len(t.Arguments))
+	for i := range t.Arguments {
+		var err error
+		values[i], err = t.Arguments[i].Get(env, arg)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't evaluate %s argument with index %d: %w", t.Name, i, err)
+		}
+	}
+	str, err := stringArgument(t.Name, 0, values[0])
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := t.Operation(env, str, values[1:])
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", t.Name, err)
+	}
+	return out, nil
+}
+
+func stringArgument(name string, i int, value interface{}) (string, error) {
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s expects a string as argument with index %d, got %v of type %s", name, i, value, reflect.TypeOf(value))
+	}
+	return str, nil
+}
+
+func NewUpper(ts ...jql.Expression) (jql.Expression, error) {
+	return newStringFunction("upper", 1, 1, func(env jql.Environment, str string, args []interface{}) (interface{}, error) {
+		return strings.ToUpper(str), nil
+	}, ts)
+}
+
+func NewLower(ts ...jql.Expression) (jql.Expression, error) {
+	return newStringFunction("lower", 1, 1, func(env jql.Environment, str string, args []interface{}) (interface{}, error) {
+		return strings.ToLower(str), nil
+	}, ts)
+}
+
+// newTrim creates a function trimming whitespace, or the runes in the optional second argument, using one of the given trim functions.
+func newTrim(name string, trimSpace func(string, func(rune) bool) string, trimCutset func(string, string) string, ts []jql.Expression) (jql.Expression, error) {
+	return newStringFunction(name, 1, 2, func(env jql.Environment, str string, args []interface{}) (interface{}, error) {
+		if len(args) == 0 {
+			return trimSpace(str, unicode.IsSpace), nil
+		}
+		cutset, err := stringArgument(name, 1, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return trimCutset(str, cutset), nil
+	}, ts)
+}
+
+func NewTrim(ts ...jql.Expression) (jql.Expression, error) {
+	return newTrim("trim", strings.TrimFunc, strings.Trim, ts)
+}
+
+func NewTrimLeft(ts ...jql.Expression) (jql.Expression, error) {
+	return newTrim("ltrim", strings.TrimLeftFunc, strings.TrimLeft, ts)
+}
+
+func NewTrimRight(ts ...jql.Expression) (jql.Expression, error) {
+	return newTrim("rtrim", strings.TrimRightFunc, strings.TrimRight, ts)
+}
+
+// NewSplit splits the string around each occurrence of the separator, or around whitespace if there's no separator.
+// An empty separator splits the string into single runes.
+func NewSplit(ts ...jql.Expression) (jql.Expression, error) {
+	return newStringFunction("split", 1, 2, func(env jql.Environment, str string, args []interface{}) (interface{}, error) {
+		var parts []string
+		if len(args) == 0 {
+			parts = strings.Fields(str)
+		} else {
+			separator, err := stringArgument("split", 1, args[0])
+			if err != nil {
+				return nil, err
+			}
+			parts = strings.Split(str, separator)
+		}
+		if err := env.ChargeElements(len(parts)); err != nil {
+			return nil, err
+		}
+
+		out := make([]interface{}, len(parts))
+		for i := range parts {
+			out[i] = parts[i]
+		}
+		return out, nil
+	}, ts)
+}
+
+func NewReplace(ts ...jql.Expression) (jql.Expression, error) {
+	return newStringFunction("replace", 3, 3, func(env jql.Environment, str string, args []interface{}) (interface{}, error) {
+		old, err := stringArgument("replace", 1, args[0])
+		if err != nil {
+			return nil, err
+		}
+		replacement, err := stringArgument("replace", 2, args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		count := strings.Count(str, old)
+		if err := env.CheckStringLength(len(str) + count*(len(replacement)-len(old))); err != nil {
+			return nil, err
+		}
+		return strings.Replace(str, old, replacement, -1), nil
+	}, ts)
+}
+
+// NewSubstr returns the part of the string starting at the given rune index, which counts from the end if it's negative.
+// The optional length limits the number of runes returned. Indices out of range are clamped.
+func NewSubstr(ts ...jql.Expression) (jql.Expression, error) {
+	return newStringFunction("substr", 2, 3, func(env jql.Environment, str string, args []interface{}) (interface{}, error) {
+		runes := []rune(str)
+		start, err := Intify(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("substr expected integer start argument: %w", err)
+		}
+		if start < 0 {
+			start += len(runes)
+		}
+		start = clamp(start, 0, len(runes))
+
+		end := len(runes)
+		if len(args) == 2 {
+			length, err := Intify(args[1])
+			if err != nil {
+				return nil, fmt.Errorf("substr expected integer length argument: %w", err)
+			}
+			if length < 0 {
+				return nil, fmt.Errorf("substr length can't be negative, is %d", length)
+			}
+			if length < end-start {
+				end = start + length
+			}
+		}
+
+		return string(runes[start:end]), nil
+	}, ts)
+}
+
+func clamp(value, min, max int) int {
+	switch {
+	case value < min:
+		return min
+	case value > max:
+		return max
+	default:
+		return value
+	}
+}
+
+// newStringPredicate creates a function checking the string against the string in the second argument.
+func newStringPredicate(name string, predicate func(str, other string) bool, ts []jql.Expression) (jql.Expression, error) {
+	return newStringFunction(name, 2, 2, func(env jql.Environment, str string, args []interface{}) (interface{}, error) {
+		other, err := stringArgument(name, 1, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return predicate(str, other), nil
+	}, ts)
+}
+
+func NewContains(ts ...jql.Expression) (jql.Expression, error) {
+	return newStringPredicate("contains", strings.Contains, ts)
+}
+
+func NewStartsWith(ts ...jql.Expression) (jql.Expression, error) {
+	return newStringPredicate("startswith", strings.HasPrefix, ts)
+}
+
+func NewEndsWith(ts ...jql.Expression) (jql.Expression, error) {
+	return newStringPredicate("endswith", strings.HasSuffix, ts)
+}
+
+// NewIndexOf returns the rune index of the first occurrence of the substring, or -1 if there's none.
+func NewIndexOf(ts ...jql.Expression) (jql.Expression, error) {
+	return newStringFunction("indexof", 2, 2, func(env jql.Environment, str string, args []interface{}) (interface{}, error) {
+		substring, err := stringArgument("indexof", 1, args[0])
+		if err != nil {
+			return nil, err
+		}
+		index := strings.Index(str, substring)
+		if index == -1 {
+			return -1, nil
+		}
+		return utf8.RuneCountInString(str[:index]), nil
+	}, ts)
+}
+
+func NewRepeat(ts ...jql.Expression) (jql.Expression, error) {
+	return newStringFunction("repeat", 2, 2, func(env jql.Environment, str string, args []interface{}) (interface{}, error) {
+		count, err := Intify(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("repeat expected integer count argument: %w", err)
+		}
+		if count < 0 {
+			return nil, fmt.Errorf("repeat count can't be negative, is %d", count)
+		}
+		// The count is clamped before multiplying, so the length can't overflow and still goes over the limit.
+		if err := env.CheckStringLength(len(str) * clamp(count, 0, jql.StringLengthHardLimit+1)); err != nil {
+			return nil, err
+		}
+		return strings.Repeat(str, count), nil
+	}, ts)
+}
+
+// newPad creates a function padding the string to the given width in runes, with spaces or the optional pad string.
+func newPad(name string, left bool, ts []jql.Expression) (jql.Expression, error) {
+	return newStringFunction(name, 2, 3, func(env jql.Environment, str string, args []interface{}) (interface{}, error) {
+		width, err := Intify(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s expected integer width argument: %w", name, err)
+		}
+		pad := " "
+		if len(args) == 2 {
+			pad, err = stringArgument(name, 2, args[1])
+			if err != nil {
+				return nil, err
+			}
+			if pad == "" {
+				return nil, fmt.Errorf("%s pad string can't be empty", name)
+			}
+		}
+
+		missing := width - utf8.RuneCountInString(str)
+		if missing <= 0 {
+			return str, nil
+		}
+		// The padding is the pad string repeated, with a prefix of its runes to fill the rest,
+		// so its length in bytes is known before building it.
+		// Each rune takes at least a byte, so clamping the rune count keeps the length from overflowing without letting it pass the limit.
+		missing = clamp(missing, 0, jql.StringLengthHardLimit+1)
+		padRunes := []rune(pad)
+		rest := string(padRunes[:missing%len(padRunes)])
+		if err := env.CheckStringLength(len(str) + missing/len(padRunes)*len(pad) + len(rest)); err != nil {
+			return nil, err
+		}
+		padding := strings.Repeat(pad, missing/len(padRunes)) + rest
+
+		if left {
+			return padding + str, nil
+		}
+		return str + padding, nil
+	}, ts)
+}
+
+func NewPadLeft(ts ...jql.Expression) (jql.Expression, error) {
+	return newPad("padleft", true, ts)
+}
+
+func NewPadRight(ts ...jql.Expression) (jql.Expression, error) {
+	return newPad("padright", false, ts)
+}
+
+// Length returns the number of runes in a string, or the number of elements in an array or object.
+type Length struct {
+	Value jql.Expression
+}
+
+func NewLength(ts ...jql.Expression) (jql.Expression, error) {
+	if len(ts) != 1 {
+		return nil, fmt.Errorf("invalid argument count to length function: %v", len(ts))
+	}
+	return Length{Value: ts[0]}, nil
+}
+
+func (t Length) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	value, err := t.Value.Get(env, arg)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't evaluate length argument: %w", err)
+	}
+
+	switch typed := value.(type) {
+	case string:
+		return utf8.RuneCountInString(typed), nil
+	case []interface{}:
+		return len(typed), nil
+	case map[string]interface{}:
+		return len(typed), nil
+	default:
+		return nil, fmt.Errorf("length expects a string, array or object, got %v of type %s", value, reflect.TypeOf(value))
+	}
+}
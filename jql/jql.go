@@ -1,7 +1,72 @@
 package jql
 
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
 type Expression interface {
-	Get(interface{}) (interface{}, error)
+	Get(env Environment, arg interface{}) (interface{}, error)
+}
+
+// Environment holds the context and limits of the evaluation and the variables visible to an expression.
+type Environment struct {
+	ctx        context.Context
+	evaluation *evaluation
+	depth      int
+	variables  *variable
+}
+
+// NewEnvironment returns an environment without any variables, evaluated within the given context.
+func NewEnvironment(ctx context.Context) Environment {
+	return Environment{ctx: ctx}
+}
+
+// WithContext returns a new environment, which will be cancelled together with the given context.
+func (env Environment) WithContext(ctx context.Context) Environment {
+	env.ctx = ctx
+	return env
+}
+
+func (env Environment) Context() context.Context {
+	if env.ctx == nil {
+		return context.Background()
+	}
+	return env.ctx
+}
+
+// Err returns a non-nil error if the evaluation has been cancelled or timed out.
+func (env Environment) Err() error {
+	if env.ctx == nil {
+		return nil
+	}
+	return env.ctx.Err()
+}
+
+type variable struct {
+	name   string
+	value  interface{}
+	parent *variable
+}
+
+// WithVariable returns a new environment with the given variable bound, shadowing any previous variable with that name.
+func (env Environment) WithVariable(name string, value interface{}) Environment {
+	env.variables = &variable{
+		name:   name,
+		value:  value,
+		parent: env.variables,
+	}
+	return env
+}
+
+func (env Environment) Variable(name string) (interface{}, bool) {
+	for v := env.variables; v != nil; v = v.parent {
+		if v.name == name {
+			return v.value, true
+		}
+	}
+	return nil, false
 }
 
 type Constant struct {
@@ -12,6 +77,181 @@ func NewConstant(value interface{}) Expression {
 	return &Constant{Value: value}
 }
 
-func (s Constant) Get(input interface{}) (interface{}, error) {
+func (s Constant) Get(env Environment, input interface{}) (interface{}, error) {
 	return s.Value, nil
 }
+
+type Variable struct {
+	Name string
+}
+
+func NewVariable(name string) Expression {
+	return &Variable{Name: name}
+}
+
+func (s Variable) Get(env Environment, input interface{}) (interface{}, error) {
+	value, ok := env.Variable(s.Name)
+	if !ok {
+		return nil, fmt.Errorf("undefined variable: $%s", s.Name)
+	}
+	return value, nil
+}
+
+// Let evaluates the values in order, binding each to its name, and then evaluates the body with all of them bound.
+// Each value can use the variables bound before it.
+type Let struct {
+	Names  []string
+	Values []Expression
+	Body   Expression
+}
+
+func NewLet(names []string, values []Expression, body Expression) Expression {
+	return &Let{
+		Names:  names,
+		Values: values,
+		Body:   body,
+	}
+}
+
+func (s Let) Get(env Environment, input interface{}) (interface{}, error) {
+	for i := range s.Values {
+		value, err := s.Values[i].Get(env, input)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't evaluate let binding $%s: %w", s.Names[i], err)
+		}
+		env = env.WithVariable(s.Names[i], value)
+	}
+
+	return s.Body.Get(env, input)
+}
+
+// Closure is a function defined in a query, either by defn or fn.
+// Its parameters are bound as variables when evaluating the body, on top of the variables visible where it was created.
+type Closure struct {
+	Name       string
+	Parameters []string
+	Body       Expression
+	Env        Environment
+}
+
+// Call evaluates the function body with the given arguments.
+// The environment of the caller is used for everything except the variables.
+func (c *Closure) Call(env Environment, arg interface{}, args []interface{}) (interface{}, error) {
+	if err := env.Step(); err != nil {
+		return nil, err
+	}
+	env, err := env.enter()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(c.Parameters) {
+		return nil, fmt.Errorf("function %s expects %d arguments, got %d", c, len(c.Parameters), len(args))
+	}
+
+	env.variables = c.Env.variables
+	for i := range c.Parameters {
+		env = env.WithVariable(c.Parameters[i], args[i])
+	}
+
+	return c.Body.Get(env, arg)
+}
+
+func (c *Closure) String() string {
+	if c.Name == "" {
+		return "<anonymous>"
+	}
+	return c.Name
+}
+
+func (c *Closure) MarshalJSON() ([]byte, error) {
+	return nil, fmt.Errorf("function %s can't be encoded as JSON", c)
+}
+
+// FunctionCall calls a function defined in the query with the values of its arguments, in the current context.
+type FunctionCall struct {
+	Function  *Closure
+	Arguments []Expression
+}
+
+func NewFunctionCall(function *Closure, args []Expression) Expression {
+	return &FunctionCall{
+		Function:  function,
+		Arguments: args,
+	}
+}
+
+func (s FunctionCall) Get(env Environment, input interface{}) (interface{}, error) {
+	args := make([]interface{}, len(s.Arguments))
+	for i := range s.Arguments {
+		var err error
+		args[i], err = s.Arguments[i].Get(env, input)
+		if err != nil {
+			if abortErr := abortError(env, err); abortErr != nil {
+				return nil, abortErr
+			}
+			return nil, fmt.Errorf("couldn't evaluate argument with index %d to function %s: %w", i, s.Function, err)
+		}
+	}
+
+	out, err := s.Function.Call(env, input, args)
+	if err != nil {
+		if abortErr := abortError(env, err); abortErr != nil {
+			return nil, abortErr
+		}
+		// In recursive functions, only the innermost frame is kept, so the error doesn't grow with the depth.
+		var frame *functionError
+		if errors.As(err, &frame) && frame.function == s.Function.String() {
+			return nil, frame
+		}
+		return nil, &functionError{function: s.Function.String(), err: err}
+	}
+	return out, nil
+}
+
+// functionError is an error which happened in a function defined in the query.
+type functionError struct {
+	function string
+	err      error
+}
+
+func (err *functionError) Error() string {
+	return fmt.Sprintf("error in function %s: %s", err.function, err.err)
+}
+
+func (err *functionError) Unwrap() error {
+	return err.err
+}
+
+// abortError returns the error which aborts the whole evaluation, if err is caused by cancellation or a limit, or nil otherwise.
+// These are returned as they are, without the context of each expression they pass through.
+func abortError(env Environment, err error) error {
+	if ctxErr := env.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		return limitErr
+	}
+	return nil
+}
+
+// Lambda evaluates to an anonymous function, closing over the current environment.
+type Lambda struct {
+	Parameters []string
+	Body       Expression
+}
+
+func NewLambda(parameters []string, body Expression) Expression {
+	return &Lambda{
+		Parameters: parameters,
+		Body:       body,
+	}
+}
+
+func (s Lambda) Get(env Environment, input interface{}) (interface{}, error) {
+	return &Closure{
+		Parameters: s.Parameters,
+		Body:       s.Body,
+		Env:        env,
+	}, nil
+}
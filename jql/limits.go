@@ -0,0 +1,121 @@
+package jql
+
+import "fmt"
+
+// Limits restricts the resources a single evaluation of a query may use.
+// A zero value for any of the limits means it's not enforced,
+// though the number of elements, the depth and the length of strings are still bounded by ElementsHardLimit, DepthHardLimit and StringLengthHardLimit.
+type Limits struct {
+	// MaxElements is the maximum total number of elements in all the arrays and objects created during evaluation.
+	MaxElements int
+	// MaxDepth is the maximum depth of nested calls to functions defined in the query.
+	MaxDepth int
+	// MaxStringLength is the maximum length in bytes of a string built during evaluation.
+	MaxStringLength int
+	// MaxSteps is the maximum number of evaluation steps, which are roughly function calls and loop iterations.
+	MaxSteps int
+}
+
+// ElementsHardLimit is the maximum total number of elements in all the arrays and objects created during evaluation,
+// enforced even if there's no MaxElements limit, so that no query can make the evaluation allocate arbitrarily large arrays.
+const ElementsHardLimit = 1 << 24
+
+// DepthHardLimit is the maximum depth of nested calls to functions defined in the query,
+// enforced even if there's no MaxDepth limit, so that unbounded recursion fails with a LimitError instead of overflowing the stack.
+const DepthHardLimit = 10000
+
+// StringLengthHardLimit is the maximum length in bytes of a string built during evaluation,
+// enforced even if there's no MaxStringLength limit, so that no query can make the evaluation allocate arbitrarily large strings.
+const StringLengthHardLimit = 1 << 26
+
+// LimitError is returned when an evaluation goes over one of its limits.
+type LimitError struct {
+	Limit string
+	Max   int
+}
+
+func (err *LimitError) Error() string {
+	return fmt.Sprintf("limit exceeded: %s (max %d)", err.Limit, err.Max)
+}
+
+// evaluation is the state shared by all environments derived from a single evaluation.
+type evaluation struct {
+	limits   Limits
+	steps    int
+	elements int
+}
+
+// WithLimits returns a new environment, which starts a fresh evaluation enforcing the given limits.
+func (env Environment) WithLimits(limits Limits) Environment {
+	env.evaluation = &evaluation{limits: limits}
+	env.depth = 0
+	return env
+}
+
+// Step records a single step of evaluation.
+// It returns a non-nil error if the evaluation has been cancelled, timed out or has gone over its step limit.
+// Functions should call it in all their loops.
+func (env Environment) Step() error {
+	return env.Steps(1)
+}
+
+// Steps records n steps of evaluation at once, for loops which do too little work in each iteration to call Step.
+func (env Environment) Steps(n int) error {
+	if err := env.Err(); err != nil {
+		return err
+	}
+	if env.evaluation == nil || env.evaluation.limits.MaxSteps == 0 {
+		return nil
+	}
+	env.evaluation.steps += n
+	if env.evaluation.steps > env.evaluation.limits.MaxSteps {
+		return &LimitError{Limit: "evaluation steps", Max: env.evaluation.limits.MaxSteps}
+	}
+	return nil
+}
+
+// ChargeElements records that n elements of a new array or object are about to be created.
+// It returns an error if that would take the evaluation over its element limit, or over ElementsHardLimit.
+func (env Environment) ChargeElements(n int) error {
+	max := ElementsHardLimit
+	if env.evaluation != nil && env.evaluation.limits.MaxElements != 0 && env.evaluation.limits.MaxElements < max {
+		max = env.evaluation.limits.MaxElements
+	}
+	charged := 0
+	if env.evaluation != nil {
+		charged = env.evaluation.elements
+	}
+	if n < 0 || n > max-charged {
+		return &LimitError{Limit: "output elements", Max: max}
+	}
+	if env.evaluation != nil {
+		env.evaluation.elements += n
+	}
+	return nil
+}
+
+// CheckStringLength returns an error if a string of n bytes would go over the string length limit, or over StringLengthHardLimit.
+// Functions should call it before allocating the string.
+func (env Environment) CheckStringLength(n int) error {
+	max := StringLengthHardLimit
+	if env.evaluation != nil && env.evaluation.limits.MaxStringLength != 0 && env.evaluation.limits.MaxStringLength < max {
+		max = env.evaluation.limits.MaxStringLength
+	}
+	if n < 0 || n > max {
+		return &LimitError{Limit: "string length", Max: max}
+	}
+	return nil
+}
+
+// enter returns the environment for the body of a called function, one level deeper.
+func (env Environment) enter() (Environment, error) {
+	max := DepthHardLimit
+	if env.evaluation != nil && env.evaluation.limits.MaxDepth != 0 && env.evaluation.limits.MaxDepth < max {
+		max = env.evaluation.limits.MaxDepth
+	}
+	env.depth++
+	if env.depth > max {
+		return env, &LimitError{Limit: "recursion depth", Max: max}
+	}
+	return env, nil
+}
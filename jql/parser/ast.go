@@ -2,17 +2,183 @@ package parser
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/cube2222/jql/jql"
 )
 
+// Query is a list of function definitions followed by the expression to evaluate.
 type Query struct {
-	Expression Expression
+	Definitions Expressions
+	Expression  Expression
+	// Source is the text the query was parsed from, which spans point into.
+	Source string
+}
+
+func (q *Query) IExpression() {}
+
+// GetExecutionExpression validates the whole query, returning a *ValidationError with all the problems found, and constructs its expression.
+func (q *Query) GetExecutionExpression(eCtx ExpressionConstructorContext) (jql.Expression, error) {
+	eCtx, err := eCtx.withDefinitions("", q.Source, q.Definitions, q.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.Expression.GetExecutionExpression(eCtx)
 }
 
 type ExpressionConstructorContext struct {
-	Functions          map[string]func(...jql.Expression) (jql.Expression, error)
-	ConstantExpression func(interface{}) jql.Expression
+	Functions              *jql.FunctionRegistry
+	ConstantExpression     func(interface{}) jql.Expression
+	VariableExpression     func(name string) jql.Expression
+	LetExpression          func(names []string, values []jql.Expression, body jql.Expression) jql.Expression
+	LambdaExpression       func(parameters []string, body jql.Expression) jql.Expression
+	FunctionCallExpression func(function *jql.Closure, args []jql.Expression) jql.Expression
+	// Variables are the names of the variables in scope.
+	Variables []string
+	// UserFunctions are the functions defined in the query with defn, or imported from libraries.
+	UserFunctions map[string]*jql.Closure
+	// LoadLibrary resolves the path of an imported library and returns its source.
+	LoadLibrary func(path string) (resolvedPath string, source string, err error)
+
+	// importStack holds the resolved paths of the libraries being imported, to detect cycles.
+	importStack []string
+}
+
+// WithVariables returns a copy of the context with the given variable names added to the scope.
+func (eCtx ExpressionConstructorContext) WithVariables(names ...string) ExpressionConstructorContext {
+	variables := make([]string, len(eCtx.Variables), len(eCtx.Variables)+len(names))
+	copy(variables, eCtx.Variables)
+	eCtx.Variables = append(variables, names...)
+	return eCtx
+}
+
+func (eCtx ExpressionConstructorContext) hasVariable(name string) bool {
+	for i := range eCtx.Variables {
+		if eCtx.Variables[i] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// withDefinitions returns a copy of the context with the functions defined by the given defn and import expressions added.
+// All the functions are declared before any body is constructed, so they can call each other recursively.
+// The function bodies and the query, if it's not nil, are validated before constructing anything.
+// The file is the path of the library the definitions come from, or empty for the query.
+func (eCtx ExpressionConstructorContext) withDefinitions(file string, source string, definitions Expressions, query Expression) (ExpressionConstructorContext, error) {
+	userFunctions := make(map[string]*jql.Closure, len(eCtx.UserFunctions)+len(definitions))
+	for name, function := range eCtx.UserFunctions {
+		userFunctions[name] = function
+	}
+
+	var bodies []Expression
+	var closures []*jql.Closure
+	for i := range definitions {
+		definition, ok := definitions[i].(*SExpression)
+		if !ok || (definition.Name != "defn" && definition.Name != "import") {
+			return eCtx, fmt.Errorf("top-level expression with index %d should be a function definition or import, only the last one can be the query", i)
+		}
+
+		if definition.Name == "import" {
+			imported, err := eCtx.importLibrary(definition)
+			if err != nil {
+				return eCtx, fmt.Errorf("couldn't import library with index %d: %w", i, err)
+			}
+			for name, function := range imported {
+				if _, ok := userFunctions[name]; ok {
+					return eCtx, fmt.Errorf("function %s is defined more than once", name)
+				}
+				userFunctions[name] = function
+			}
+			continue
+		}
+
+		if len(definition.Args) != 3 {
+			return eCtx, fmt.Errorf("function definition with index %d should be of the form (defn name (parameters...) body)", i)
+		}
+		name, ok := definition.Args[0].(*Symbol)
+		if !ok {
+			return eCtx, fmt.Errorf("function definition with index %d should start with the function name", i)
+		}
+		if strings.Contains(name.Name, "/") {
+			return eCtx, fmt.Errorf("function name %s can't contain a /, it's reserved for imported libraries", name.Name)
+		}
+		if _, ok := eCtx.Functions.Lookup(name.Name); ok {
+			return eCtx, fmt.Errorf("can't redefine built-in function %s", name.Name)
+		}
+		if _, ok := userFunctions[name.Name]; ok {
+			return eCtx, fmt.Errorf("function %s is defined more than once", name.Name)
+		}
+		parameters, err := parameterNames(definition.Args[1])
+		if err != nil {
+			return eCtx, fmt.Errorf("invalid parameters of function %s: %w", name.Name, err)
+		}
+
+		closure := &jql.Closure{
+			Name:       name.Name,
+			Parameters: parameters,
+		}
+		closures = append(closures, closure)
+		bodies = append(bodies, definition.Args[2])
+		userFunctions[name.Name] = closure
+	}
+	eCtx.UserFunctions = userFunctions
+
+	v := &validator{file: file, source: source}
+	for i := range closures {
+		bodyCtx := eCtx
+		bodyCtx.Variables = nil
+		v.validate(bodyCtx.WithVariables(closures[i].Parameters...), bodies[i])
+	}
+	if query != nil {
+		v.validate(eCtx, query)
+	}
+	if err := v.err(); err != nil {
+		return eCtx, err
+	}
+
+	for i := range closures {
+		bodyCtx := eCtx
+		bodyCtx.Variables = nil
+		body, err := bodies[i].GetExecutionExpression(bodyCtx.WithVariables(closures[i].Parameters...))
+		if err != nil {
+			return eCtx, fmt.Errorf("couldn't get expression for body of function %s: %w", closures[i].Name, err)
+		}
+		closures[i].Body = body
+	}
+
+	return eCtx, nil
+}
+
+// parameterNames extracts the names out of a parameter list like (a b c) or ().
+func parameterNames(expr Expression) ([]string, error) {
+	list, ok := expr.(*SExpression)
+	if !ok {
+		return nil, fmt.Errorf("parameters should be a list of names")
+	}
+	if list.Name == "" {
+		return nil, nil
+	}
+
+	names := []string{list.Name}
+	for i := range list.Args {
+		symbol, ok := list.Args[i].(*Symbol)
+		if !ok {
+			return nil, fmt.Errorf("parameter with index %d should be a name", i+1)
+		}
+		names = append(names, symbol.Name)
+	}
+
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate parameter %s", name)
+		}
+		seen[name] = true
+	}
+
+	return names, nil
 }
 
 type Expression interface {
@@ -23,12 +189,27 @@ type Expression interface {
 type SExpression struct {
 	Name string
 	Args []Expression
+	Span Span
+}
+
+// newSExpression creates a function call if the head is a name, otherwise it's an elem shortcut.
+func newSExpression(head Expression, args Expressions, span Span) *SExpression {
+	if symbol, ok := head.(*Symbol); ok {
+		return &SExpression{Name: symbol.Name, Args: args, Span: span}
+	}
+	return &SExpression{Name: "elem", Args: append([]Expression{head}, args...), Span: span}
 }
 
 func (e *SExpression) GetExecutionExpression(eCtx ExpressionConstructorContext) (jql.Expression, error) {
-	f, ok := eCtx.Functions[e.Name]
-	if !ok {
-		return nil, fmt.Errorf("no such function: %s", e.Name)
+	switch e.Name {
+	case "":
+		return nil, fmt.Errorf("empty expression")
+	case "let":
+		return e.getLetExpression(eCtx)
+	case "fn":
+		return e.getLambdaExpression(eCtx)
+	case "defn", "import":
+		return nil, fmt.Errorf("%s can only be used at the top level, before the query", e.Name)
 	}
 
 	arguments := make([]jql.Expression, len(e.Args))
@@ -37,24 +218,83 @@ func (e *SExpression) GetExecutionExpression(eCtx ExpressionConstructorContext)
 		if err != nil {
 			return nil, fmt.Errorf("couldn't get argument expression with index %d: %w", i, err)
 		}
-
 		arguments[i] = expr
 	}
 
-	expr, err := f(arguments...)
+	if userFunction, ok := eCtx.UserFunctions[e.Name]; ok {
+		if len(arguments) != len(userFunction.Parameters) {
+			return nil, fmt.Errorf("function %s expects %d arguments, got %d", e.Name, len(userFunction.Parameters), len(arguments))
+		}
+		return eCtx.FunctionCallExpression(userFunction, arguments), nil
+	}
+
+	f, ok := eCtx.Functions.Lookup(e.Name)
+	if !ok {
+		return nil, fmt.Errorf("no such function: %s", e.Name)
+	}
+	expr, err := f.New(arguments...)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't get expression for function %s: %w", e.Name, err)
 	}
-
 	return expr, nil
 }
 
+// getLetExpression handles (let (name1 value1) (name2 value2) ... body).
+func (e *SExpression) getLetExpression(eCtx ExpressionConstructorContext) (jql.Expression, error) {
+	if len(e.Args) < 2 {
+		return nil, fmt.Errorf("let needs at least one binding and a body, got %d arguments", len(e.Args))
+	}
+
+	bindings := e.Args[:len(e.Args)-1]
+	names := make([]string, len(bindings))
+	values := make([]jql.Expression, len(bindings))
+	for i := range bindings {
+		binding, ok := bindings[i].(*SExpression)
+		if !ok || len(binding.Args) != 1 || binding.Name == "elem" {
+			return nil, fmt.Errorf("let binding with index %d should be of the form (name value)", i)
+		}
+		value, err := binding.Args[0].GetExecutionExpression(eCtx)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get expression for let binding $%s: %w", binding.Name, err)
+		}
+		names[i] = binding.Name
+		values[i] = value
+		eCtx = eCtx.WithVariables(binding.Name)
+	}
+
+	body, err := e.Args[len(e.Args)-1].GetExecutionExpression(eCtx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get expression for let body: %w", err)
+	}
+
+	return eCtx.LetExpression(names, values, body), nil
+}
+
+// getLambdaExpression handles (fn (parameters...) body).
+func (e *SExpression) getLambdaExpression(eCtx ExpressionConstructorContext) (jql.Expression, error) {
+	if len(e.Args) != 2 {
+		return nil, fmt.Errorf("fn should be of the form (fn (parameters...) body), got %d arguments", len(e.Args))
+	}
+	parameters, err := parameterNames(e.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid fn parameters: %w", err)
+	}
+
+	body, err := e.Args[1].GetExecutionExpression(eCtx.WithVariables(parameters...))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get expression for fn body: %w", err)
+	}
+
+	return eCtx.LambdaExpression(parameters, body), nil
+}
+
 func (e *SExpression) IExpression() {}
 
 type Expressions []Expression
 
 type Constant struct {
 	Value interface{}
+	Span  Span
 }
 
 func (e *Constant) IExpression() {}
@@ -62,3 +302,33 @@ func (e *Constant) IExpression() {}
 func (e *Constant) GetExecutionExpression(eCtx ExpressionConstructorContext) (jql.Expression, error) {
 	return eCtx.ConstantExpression(e.Value), nil
 }
+
+type Variable struct {
+	Name string
+	Span Span
+}
+
+func (e *Variable) IExpression() {}
+
+func (e *Variable) GetExecutionExpression(eCtx ExpressionConstructorContext) (jql.Expression, error) {
+	if !eCtx.hasVariable(e.Name) {
+		return nil, fmt.Errorf("undefined variable: $%s", e.Name)
+	}
+	return eCtx.VariableExpression(e.Name), nil
+}
+
+// Symbol is a bare name. It can be used to refer to a function defined in the query, as a function value.
+type Symbol struct {
+	Name string
+	Span Span
+}
+
+func (e *Symbol) IExpression() {}
+
+func (e *Symbol) GetExecutionExpression(eCtx ExpressionConstructorContext) (jql.Expression, error) {
+	userFunction, ok := eCtx.UserFunctions[e.Name]
+	if !ok {
+		return nil, fmt.Errorf("unexpected name %s, functions are called like (%s ...)", e.Name, e.Name)
+	}
+	return eCtx.ConstantExpression(userFunction), nil
+}
@@ -7,10 +7,12 @@ import __yyfmt__ "fmt"
 
 //line lang.y:2
 
-//line lang.y:6
+import "encoding/json"
+
+//line lang.y:7
 type yySymType struct {
 	yys         int
-	int         int
+	number      json.Number
 	bytes       []byte
 	string      string
 	bool        bool
@@ -20,13 +22,17 @@ type yySymType struct {
 	expressions Expressions
 	query       *Query
 	constant    *Constant
+	variable    *Variable
+	symbol      *Symbol
+	span        Span
 }
 
 const ID = 57346
 const STRING = 57347
-const INTEGER = 57348
+const NUMBER = 57348
 const BOOLEAN = 57349
 const NULL = 57350
+const VARIABLE = 57351
 
 var yyToknames = [...]string{
 	"$end",
@@ -34,12 +40,14 @@ var yyToknames = [...]string{
 	"$unk",
 	"ID",
 	"STRING",
-	"INTEGER",
+	"NUMBER",
 	"BOOLEAN",
 	"NULL",
+	"VARIABLE",
 	"'('",
 	"')'",
 }
+
 var yyStatenames = [...]string{}
 
 const yyEofCode = 1
@@ -55,55 +63,58 @@ var yyExca = [...]int{
 
 const yyPrivate = 57344
 
-const yyLast = 23
+const yyLast = 28
 
 var yyAct = [...]int{
-
-	14, 2, 10, 5, 6, 7, 8, 9, 12, 18,
-	11, 16, 1, 13, 17, 5, 6, 7, 8, 9,
-	15, 4, 3,
+	3, 2, 20, 15, 14, 8, 9, 10, 11, 13,
+	12, 16, 1, 17, 18, 5, 7, 6, 4, 19,
+	15, 14, 8, 9, 10, 11, 13, 12,
 }
-var yyPact = [...]int{
 
-	10, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -2,
-	10, 10, 1, 10, -1000, -1, -1000, -1000, -1000,
+var yyPact = [...]int{
+	17, -1000, 17, -1000, -1000, -1000, -1000, -1000, -1000, -1000,
+	-1000, -1000, 0, -1000, -1000, -1000, -1000, 17, -9, 17,
+	-1000,
 }
-var yyPgo = [...]int{
 
-	0, 0, 22, 21, 8, 13, 12,
+var yyPgo = [...]int{
+	0, 0, 18, 17, 16, 15, 14, 1, 12,
 }
-var yyR1 = [...]int{
 
-	0, 6, 1, 1, 2, 2, 2, 2, 3, 3,
-	4, 4, 5, 5,
+var yyR1 = [...]int{
+	0, 8, 1, 1, 1, 1, 2, 2, 2, 2,
+	3, 4, 5, 5, 6, 6, 7, 7,
 }
-var yyR2 = [...]int{
 
-	0, 1, 1, 1, 1, 1, 1, 1, 4, 4,
-	0, 1, 1, 2,
+var yyR2 = [...]int{
+	0, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+	1, 1, 2, 4, 0, 1, 1, 2,
 }
-var yyChk = [...]int{
 
-	-1000, -6, -1, -2, -3, 5, 6, 7, 8, 9,
-	4, -1, -4, -5, -1, -4, 10, -1, 10,
+var yyChk = [...]int{
+	-1000, -8, -7, -1, -2, -5, -3, -4, 5, 6,
+	7, 8, 10, 9, 4, -1, 11, -1, -6, -7,
+	11,
 }
-var yyDef = [...]int{
 
-	0, -2, 1, 2, 3, 4, 5, 6, 7, 0,
-	10, 10, 0, 11, 12, 0, 8, 13, 9,
+var yyDef = [...]int{
+	0, -2, 1, 16, 2, 3, 4, 5, 6, 7,
+	8, 9, 0, 10, 11, 17, 12, 14, 0, 15,
+	13,
 }
-var yyTok1 = [...]int{
 
+var yyTok1 = [...]int{
 	1, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	9, 10,
+	10, 11,
 }
-var yyTok2 = [...]int{
 
-	2, 3, 4, 5, 6, 7, 8,
+var yyTok2 = [...]int{
+	2, 3, 4, 5, 6, 7, 8, 9,
 }
+
 var yyTok3 = [...]int{
 	0,
 }
@@ -447,80 +458,104 @@ yydefault:
 
 	case 1:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line lang.y:38
+//line lang.y:45
 		{
-			yyVAL.query = &Query{Expression: yyDollar[1].expression}
+			yyVAL.query = &Query{Definitions: yyDollar[1].expressions[:len(yyDollar[1].expressions)-1], Expression: yyDollar[1].expressions[len(yyDollar[1].expressions)-1]}
 			setQuery(yylex, yyVAL.query)
 		}
 	case 2:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line lang.y:45
+//line lang.y:52
 		{
 			yyVAL.expression = yyDollar[1].constant
 		}
 	case 3:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line lang.y:49
+//line lang.y:56
 		{
 			yyVAL.expression = yyDollar[1].sexpression
 		}
 	case 4:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line lang.y:55
+//line lang.y:60
 		{
-			yyVAL.constant = &Constant{Value: yyDollar[1].string}
+			yyVAL.expression = yyDollar[1].variable
 		}
 	case 5:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line lang.y:59
+//line lang.y:64
 		{
-			yyVAL.constant = &Constant{Value: yyDollar[1].int}
+			yyVAL.expression = yyDollar[1].symbol
 		}
 	case 6:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line lang.y:63
+//line lang.y:70
 		{
-			yyVAL.constant = &Constant{Value: yyDollar[1].bool}
+			yyVAL.constant = &Constant{Value: yyDollar[1].string, Span: yyDollar[1].span}
 		}
 	case 7:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line lang.y:67
+//line lang.y:74
 		{
-			yyVAL.constant = &Constant{Value: nil}
+			yyVAL.constant = &Constant{Value: yyDollar[1].number, Span: yyDollar[1].span}
 		}
 	case 8:
-		yyDollar = yyS[yypt-4 : yypt+1]
-//line lang.y:73
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line lang.y:78
 		{
-			yyVAL.sexpression = &SExpression{Name: string(yyDollar[2].bytes), Args: yyDollar[3].expressions}
+			yyVAL.constant = &Constant{Value: yyDollar[1].bool, Span: yyDollar[1].span}
 		}
 	case 9:
-		yyDollar = yyS[yypt-4 : yypt+1]
-//line lang.y:77
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line lang.y:82
 		{
-			yyVAL.sexpression = &SExpression{Name: "elem", Args: append([]Expression{yyDollar[2].expression}, yyDollar[3].expressions...)}
+			yyVAL.constant = &Constant{Value: nil, Span: yyDollar[1].span}
 		}
 	case 10:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line lang.y:88
+		{
+			yyVAL.variable = &Variable{Name: yyDollar[1].string, Span: yyDollar[1].span}
+		}
+	case 11:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line lang.y:94
+		{
+			yyVAL.symbol = &Symbol{Name: string(yyDollar[1].bytes), Span: yyDollar[1].span}
+		}
+	case 12:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line lang.y:100
+		{
+			yyVAL.sexpression = &SExpression{Span: Span{Start: yyDollar[1].span.Start, End: yyDollar[2].span.End}}
+		}
+	case 13:
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line lang.y:104
+		{
+			yyVAL.sexpression = newSExpression(yyDollar[2].expression, yyDollar[3].expressions, Span{Start: yyDollar[1].span.Start, End: yyDollar[4].span.End})
+		}
+	case 14:
 		yyDollar = yyS[yypt-0 : yypt+1]
-//line lang.y:82
+//line lang.y:109
 		{
 			yyVAL.expressions = nil
 		}
-	case 11:
+	case 15:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line lang.y:86
+//line lang.y:113
 		{
 			yyVAL.expressions = yyDollar[1].expressions
 		}
-	case 12:
+	case 16:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line lang.y:92
+//line lang.y:119
 		{
 			yyVAL.expressions = []Expression{yyDollar[1].expression}
 		}
-	case 13:
+	case 17:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line lang.y:96
+//line lang.y:123
 		{
 			yyVAL.expressions = append(yyVAL.expressions, yyDollar[2].expression)
 		}
@@ -1,10 +1,11 @@
 %{
 package parser
 
+import "encoding/json"
 %}
 
 %union {
-  int int
+  number json.Number
   bytes []byte
   string string
   bool bool
@@ -14,16 +15,22 @@ package parser
   expressions Expressions
   query *Query
   constant *Constant
+  variable *Variable
+  symbol *Symbol
+  span Span
 }
 %token <bytes> ID
 %token <string> STRING
-%token <int> INTEGER
+%token <number> NUMBER
 %token <bool> BOOLEAN
 %token <null> NULL
+%token <string> VARIABLE
 %token <empty> '(' ')'
 
 %type <expression> expression
 %type <constant> constant
+%type <variable> variable
+%type <symbol> symbol
 %type <sexpression> sexpr
 %type <expressions> args_opt
 %type <expressions> args
@@ -34,9 +41,9 @@ package parser
 %%
 
 query:
-  expression
+  args
   {
-    $$ = &Query{Expression: $1}
+    $$ = &Query{Definitions: $1[:len($1)-1], Expression: $1[len($1)-1]}
     setQuery(yylex, $$)
   }
 
@@ -49,34 +56,54 @@ expression:
   {
     $$ = $1
   }
+| variable
+  {
+    $$ = $1
+  }
+| symbol
+  {
+    $$ = $1
+  }
 
 constant:
   STRING
   {
-    $$ = &Constant{Value: $1}
+    $$ = &Constant{Value: $1, Span: $<span>1}
   }
-| INTEGER
+| NUMBER
   {
-    $$ = &Constant{Value: $1}
+    $$ = &Constant{Value: $1, Span: $<span>1}
   }
 | BOOLEAN
 	{
-		$$ = &Constant{Value: $1}
+		$$ = &Constant{Value: $1, Span: $<span>1}
 	}
 | NULL
 	{
-		$$ = &Constant{Value: nil}
+		$$ = &Constant{Value: nil, Span: $<span>1}
 	}
 
+variable:
+  VARIABLE
+  {
+    $$ = &Variable{Name: $1, Span: $<span>1}
+  }
+
+symbol:
+  ID
+  {
+    $$ = &Symbol{Name: string($1), Span: $<span>1}
+  }
+
 sexpr:
-  '(' ID args_opt ')'
+  '(' ')'
   {
-    $$ = &SExpression{Name: string($2), Args: $3}
+    $$ = &SExpression{Span: Span{Start: $<span>1.Start, End: $<span>2.End}}
   }
 | '(' expression args_opt ')'
-    {
-      $$ = &SExpression{Name: "elem", Args: append([]Expression{$2}, $3...)}
-    }
+  {
+    $$ = newSExpression($2, $3, Span{Start: $<span>1.Start, End: $<span>4.End})
+  }
 
 args_opt:
   {
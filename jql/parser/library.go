@@ -0,0 +1,124 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cube2222/jql/jql"
+)
+
+// importLibrary handles (import "path/to/library.jql") and (import "path/to/library.jql" "namespace").
+// It returns the functions defined in the library, prefixed with the namespace, which defaults to the file name without extension.
+func (eCtx ExpressionConstructorContext) importLibrary(e *SExpression) (map[string]*jql.Closure, error) {
+	if len(e.Args) != 1 && len(e.Args) != 2 {
+		return nil, fmt.Errorf("import should be of the form (import \"path\") or (import \"path\" \"namespace\"), got %d arguments", len(e.Args))
+	}
+	path, err := stringConstant(e.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid import path: %w", err)
+	}
+	namespace := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if len(e.Args) == 2 {
+		namespace, err = stringConstant(e.Args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid import namespace: %w", err)
+		}
+	}
+	if identifierRegexp.FindString(namespace) != namespace {
+		return nil, fmt.Errorf("invalid namespace %s for library %s, pass a valid one as the second argument to import", namespace, path)
+	}
+
+	if eCtx.LoadLibrary == nil {
+		return nil, fmt.Errorf("importing libraries isn't supported")
+	}
+	resolvedPath, source, err := eCtx.LoadLibrary(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load library %s: %w", path, err)
+	}
+	for i := range eCtx.importStack {
+		if eCtx.importStack[i] == resolvedPath {
+			return nil, fmt.Errorf("import cycle: %s", strings.Join(append(eCtx.importStack[i:], resolvedPath), " -> "))
+		}
+	}
+
+	definitions, err := ParseLibrary(source)
+	if err != nil {
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) && parseErr.Lexical {
+			// The file may not be a library at all, so none of its contents are included in the error.
+			return nil, fmt.Errorf("couldn't parse library %s: invalid token at line %d, column %d", resolvedPath, parseErr.Line, parseErr.Column)
+		}
+		return nil, fmt.Errorf("couldn't parse library %s: %w", resolvedPath, err)
+	}
+
+	libraryCtx := eCtx
+	libraryCtx.Variables = nil
+	libraryCtx.UserFunctions = nil
+	libraryCtx.importStack = append(append([]string{}, eCtx.importStack...), resolvedPath)
+	libraryCtx, err = libraryCtx.withDefinitions(resolvedPath, source, definitions, nil)
+	if err != nil {
+		// Validation problems already name the library they're in.
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("in library %s: %w", resolvedPath, err)
+	}
+
+	out := make(map[string]*jql.Closure)
+	for name, function := range libraryCtx.UserFunctions {
+		// Functions the library imported itself aren't re-exported.
+		if strings.Contains(name, "/") {
+			continue
+		}
+		out[namespace+"/"+name] = function
+	}
+
+	return out, nil
+}
+
+func stringConstant(expr Expression) (string, error) {
+	constant, ok := expr.(*Constant)
+	if !ok {
+		return "", fmt.Errorf("should be a string literal")
+	}
+	str, ok := constant.Value.(string)
+	if !ok {
+		return "", fmt.Errorf("should be a string literal, is %v", constant.Value)
+	}
+	return str, nil
+}
+
+// LibraryLoader returns a LoadLibrary function which looks for library paths in the given directories, in order.
+// Paths have to be relative and can't use .. to leave the directories, so imports can only read files in the search path.
+func LibraryLoader(searchPath []string) func(path string) (string, string, error) {
+	return func(path string) (string, string, error) {
+		cleaned := filepath.Clean(filepath.FromSlash(path))
+		if filepath.IsAbs(cleaned) || filepath.VolumeName(cleaned) != "" || strings.HasPrefix(cleaned, string(filepath.Separator)) ||
+			cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+			return "", "", fmt.Errorf("library path should be relative to the search path and can't leave it")
+		}
+
+		for _, dir := range searchPath {
+			candidate := filepath.Join(dir, cleaned)
+			source, err := ioutil.ReadFile(candidate)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return "", "", err
+			}
+			resolvedPath, err := filepath.Abs(candidate)
+			if err != nil {
+				resolvedPath = candidate
+			}
+			return resolvedPath, string(source), nil
+		}
+
+		return "", "", fmt.Errorf("library not found in search path: %s", strings.Join(searchPath, string(filepath.ListSeparator)))
+	}
+}
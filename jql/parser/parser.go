@@ -1,11 +1,104 @@
 package parser
 
-func Parse(query string) Expression {
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+func init() {
+	yyErrorVerbose = true
+}
+
+func Parse(query string) (Expression, error) {
+	return parse(query)
+}
+
+// ParseLibrary parses the contents of a library file, which should only contain function definitions and imports.
+func ParseLibrary(source string) (Expressions, error) {
+	query, err := parse(source)
+	if err != nil {
+		return nil, err
+	}
+	return append(query.Definitions, query.Expression), nil
+}
+
+func parse(query string) (*Query, error) {
 	tokenizer := &Tokenizer{
 		queryText: query,
 		index:     0,
 	}
 	yyParse(tokenizer)
+	if tokenizer.err != nil {
+		return nil, tokenizer.err
+	}
+	return tokenizer.query, nil
+}
+
+// ParseError describes where and why a query failed to parse.
+type ParseError struct {
+	Query string
+	// Offset is the byte offset of the offending token in the query.
+	Offset int
+	// Line and Column are 1-based, Column is counted in runes.
+	Line   int
+	Column int
+	// Token is the source text of the offending token, empty at the end of the query.
+	Token    string
+	Expected []string
+	Message  string
+	// Lexical is true if the offending token itself is invalid, like an unterminated string, rather than out of place.
+	Lexical bool
+}
+
+func newParseError(query string, offset int, token string, message string, expected []string) *ParseError {
+	line, column := position(query, offset)
+	return &ParseError{
+		Query:    query,
+		Offset:   offset,
+		Line:     line,
+		Column:   column,
+		Token:    token,
+		Expected: expected,
+		Message:  message,
+	}
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("syntax error at line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// Snippet returns the offending line of the query with a caret pointing at the error position.
+func (e *ParseError) Snippet() string {
+	return snippet(e.Query, e.Offset)
+}
+
+// position returns the 1-based line and column of the byte offset in the query, the column is counted in runes.
+func position(query string, offset int) (line int, column int) {
+	lineStart := strings.LastIndexByte(query[:offset], '\n') + 1
+	return strings.Count(query[:offset], "\n") + 1, utf8.RuneCountInString(query[lineStart:offset]) + 1
+}
+
+// snippet returns the line of the query containing the byte offset, with a caret pointing at it.
+func snippet(query string, offset int) string {
+	lineStart := strings.LastIndexByte(query[:offset], '\n') + 1
+	lineEnd := strings.IndexByte(query[offset:], '\n')
+	if lineEnd == -1 {
+		lineEnd = len(query)
+	} else {
+		lineEnd += offset
+	}
+	line := query[lineStart:lineEnd]
+
+	var caret strings.Builder
+	for _, r := range query[lineStart:offset] {
+		if r == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	caret.WriteRune('^')
 
-	return tokenizer.query.Expression
+	return fmt.Sprintf("%s\n%s", line, caret.String())
 }
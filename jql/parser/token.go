@@ -1,86 +1,230 @@
 package parser
 
 import (
-	"log"
+	"encoding/json"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf16"
 )
 
 type Tokenizer struct {
-	queryText string
-	index     int
-	query     *Query
+	queryText  string
+	index      int
+	tokenStart int
+	query      *Query
+	err        *ParseError
 }
 
 func setQuery(tokenizer interface{}, query *Query) {
+	query.Source = tokenizer.(*Tokenizer).queryText
 	tokenizer.(*Tokenizer).query = query
 }
 
-var integerRegexp = regexp.MustCompile("[0-9]+")
-var identifierRegexp = regexp.MustCompile("[a-zA-Z][a-zA-Z0-9]*")
+var numberRegexp = regexp.MustCompile(`^-?(?:0|[1-9][0-9]*)(?:\.[0-9]+)?(?:[eE][+-]?[0-9]+)?`)
+var identifierRegexp = regexp.MustCompile("^[a-zA-Z][a-zA-Z0-9]*(?:/[a-zA-Z][a-zA-Z0-9]*)*")
+var variableRegexp = regexp.MustCompile(`^\$[a-zA-Z][a-zA-Z0-9]*`)
 var stringRegexp = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
 
 func (t *Tokenizer) Lex(lval *yySymType) int {
+	token := t.lex(lval)
+	lval.span = Span{Start: t.tokenStart, End: t.index}
+	return token
+}
+
+func (t *Tokenizer) lex(lval *yySymType) int {
+	t.skipWhitespaceAndComments()
+	t.tokenStart = t.index
 	if t.index == len(t.queryText) {
 		return -1
 	}
-	for unicode.IsSpace(rune(t.queryText[t.index])) {
-		t.index++
-	}
 
 	ch := t.queryText[t.index]
-
 	if ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') {
-		if strings.HasPrefix(t.queryText[t.index:], "true") {
+		indices := identifierRegexp.FindStringIndex(t.queryText[t.index:])
+		identifier := t.queryText[t.index : t.index+indices[1]]
+		t.index += indices[1]
+		switch identifier {
+		case "true":
 			lval.bool = true
-			t.index += len("true")
 			return BOOLEAN
-		}
-		if strings.HasPrefix(t.queryText[t.index:], "false") {
+		case "false":
 			lval.bool = false
-			t.index += len("false")
 			return BOOLEAN
-		}
-		if strings.HasPrefix(t.queryText[t.index:], "null") {
+		case "null":
 			lval.null = nil
-			t.index += len("null")
 			return NULL
 		}
-
-		indices := identifierRegexp.FindStringIndex(t.queryText[t.index:])
-
-		lval.bytes = []byte(t.queryText[t.index : t.index+indices[1]])
-		t.index += indices[1]
+		lval.bytes = []byte(identifier)
 		return ID
 	}
-	if ch >= '0' && ch <= '9' {
-		indices := integerRegexp.FindStringIndex(t.queryText[t.index:])
-
-		var err error
-		lval.int, err = strconv.Atoi(t.queryText[t.index : t.index+indices[1]])
-		if err != nil {
+	if (ch >= '0' && ch <= '9') || ch == '-' {
+		indices := numberRegexp.FindStringIndex(t.queryText[t.index:])
+		if indices == nil {
+			t.lexError(t.index+1, "invalid number")
 			return -1
 		}
-		t.index += indices[1]
-		return INTEGER
+		end := t.index + indices[1]
+		if end < len(t.queryText) && isNumberContinuation(t.queryText[end]) {
+			t.lexError(end+1, "invalid number")
+			return -1
+		}
+		lval.number = json.Number(t.queryText[t.index:end])
+		t.index = end
+		return NUMBER
 	}
-
 	switch ch {
 	case '"':
 		indices := stringRegexp.FindStringIndex(t.queryText[t.index:])
-		lval.string = t.queryText[t.index+1 : t.index+indices[1]-1]
+		if indices == nil {
+			t.lexError(len(t.queryText), "unterminated string")
+			return -1
+		}
+		value, err := unquote(t.queryText[t.index+1 : t.index+indices[1]-1])
+		if err != nil {
+			t.lexError(t.index+indices[1], fmt.Sprintf("invalid string: %s", err))
+			return -1
+		}
+		lval.string = value
 		t.index += indices[1]
 		return STRING
+	case '`':
+		length := strings.IndexByte(t.queryText[t.index+1:], '`')
+		if length == -1 {
+			t.lexError(len(t.queryText), "unterminated raw string")
+			return -1
+		}
+		lval.string = t.queryText[t.index+1 : t.index+1+length]
+		t.index += length + 2
+		return STRING
+	case '$':
+		indices := variableRegexp.FindStringIndex(t.queryText[t.index:])
+		if indices == nil {
+			t.lexError(t.index+1, "invalid variable name")
+			return -1
+		}
+		lval.string = t.queryText[t.index+1 : t.index+indices[1]]
+		t.index += indices[1]
+		return VARIABLE
 	case '(', ')':
 		t.index++
 		return int(ch)
 	}
 
+	t.lexError(t.index+1, fmt.Sprintf("unexpected character %q", ch))
 	return -1
 }
 
-func (t Tokenizer) Error(s string) {
-	log.Fatalf("error at %d: %s", t.index, s)
+// unquote decodes the escape sequences of a JSON string body.
+func unquote(text string) (string, error) {
+	if strings.IndexByte(text, '\\') == -1 {
+		return text, nil
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' {
+			out.WriteByte(text[i])
+			continue
+		}
+
+		i++
+		switch text[i] {
+		case '"', '\\', '/':
+			out.WriteByte(text[i])
+		case 'b':
+			out.WriteByte('\b')
+		case 'f':
+			out.WriteByte('\f')
+		case 'n':
+			out.WriteByte('\n')
+		case 'r':
+			out.WriteByte('\r')
+		case 't':
+			out.WriteByte('\t')
+		case 'u':
+			r, err := unquoteUnicode(text[i+1:])
+			if err != nil {
+				return "", err
+			}
+			i += 4
+			if utf16.IsSurrogate(r) {
+				if !strings.HasPrefix(text[i+1:], `\u`) {
+					return "", fmt.Errorf("unpaired surrogate \\u%04x", r)
+				}
+				low, err := unquoteUnicode(text[i+3:])
+				if err != nil {
+					return "", err
+				}
+				r = utf16.DecodeRune(r, low)
+				i += 6
+			}
+			out.WriteRune(r)
+		default:
+			return "", fmt.Errorf("invalid escape sequence \\%c", text[i])
+		}
+	}
+
+	return out.String(), nil
+}
+
+func unquoteUnicode(text string) (rune, error) {
+	if len(text) < 4 {
+		return 0, fmt.Errorf("invalid unicode escape sequence \\u%s", text)
+	}
+	r, err := strconv.ParseUint(text[:4], 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid unicode escape sequence \\u%s", text[:4])
+	}
+	return rune(r), nil
+}
+
+func isNumberContinuation(ch byte) bool {
+	return ('0' <= ch && ch <= '9') || ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ch == '.' || ch == '-' || ch == '+'
+}
+
+// skipWhitespaceAndComments advances past whitespace and comments, which start with a ; and last until the end of the line.
+func (t *Tokenizer) skipWhitespaceAndComments() {
+	for t.index < len(t.queryText) {
+		switch {
+		case unicode.IsSpace(rune(t.queryText[t.index])):
+			t.index++
+		case t.queryText[t.index] == ';':
+			lineEnd := strings.IndexByte(t.queryText[t.index:], '\n')
+			if lineEnd == -1 {
+				t.index = len(t.queryText)
+			} else {
+				t.index += lineEnd + 1
+			}
+		default:
+			return
+		}
+	}
+}
+
+// lexError records an error for the token starting at tokenStart and ending at end.
+func (t *Tokenizer) lexError(end int, message string) {
+	if t.err != nil {
+		return
+	}
+	err := newParseError(t.queryText, t.tokenStart, t.queryText[t.tokenStart:end], message, nil)
+	err.Lexical = true
+	t.err = err
+}
+
+func (t *Tokenizer) Error(s string) {
+	if t.err != nil {
+		return
+	}
+
+	token := t.queryText[t.tokenStart:t.index]
+	message := strings.Replace(strings.TrimPrefix(s, "syntax error: "), "$end", "end of query", -1)
+	var expected []string
+	if i := strings.Index(message, ", expecting "); i != -1 {
+		expected = strings.Split(message[i+len(", expecting "):], " or ")
+	}
+
+	t.err = newParseError(t.queryText, t.tokenStart, token, message, expected)
 }
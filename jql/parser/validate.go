@@ -0,0 +1,247 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cube2222/jql/jql"
+)
+
+// Span is the range of byte offsets in the query an expression was parsed from.
+type Span struct {
+	Start int
+	End   int
+}
+
+// Problem is a single issue found while validating a query.
+type Problem struct {
+	// File is the resolved path of the library the problem is in, or empty if it's in the query itself.
+	File string
+	// Query is the source text of the query or library the problem is in.
+	Query string
+	Span  Span
+	// Line and Column are 1-based, Column is counted in runes.
+	Line    int
+	Column  int
+	Message string
+}
+
+func newProblem(file string, query string, span Span, message string) *Problem {
+	line, column := position(query, span.Start)
+	return &Problem{
+		File:    file,
+		Query:   query,
+		Span:    span,
+		Line:    line,
+		Column:  column,
+		Message: message,
+	}
+}
+
+func (p *Problem) Error() string {
+	if p.File != "" {
+		return fmt.Sprintf("library %s, line %d, column %d: %s", p.File, p.Line, p.Column, p.Message)
+	}
+	return fmt.Sprintf("line %d, column %d: %s", p.Line, p.Column, p.Message)
+}
+
+// Snippet returns the offending line of the query or library with a caret pointing at the start of the problem.
+func (p *Problem) Snippet() string {
+	return snippet(p.Query, p.Span.Start)
+}
+
+// ValidationError lists all the problems found in a query before constructing any of its expressions.
+type ValidationError struct {
+	Problems []*Problem
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return e.Problems[0].Error()
+	}
+	messages := make([]string, len(e.Problems))
+	for i := range e.Problems {
+		messages[i] = e.Problems[i].Error()
+	}
+	return fmt.Sprintf("found %d problems:\n%s", len(e.Problems), strings.Join(messages, "\n"))
+}
+
+// Snippets returns all the problems, each followed by the offending line of the query.
+func (e *ValidationError) Snippets() string {
+	out := make([]string, len(e.Problems))
+	for i := range e.Problems {
+		out[i] = fmt.Sprintf("%s\n%s", e.Problems[i].Error(), e.Problems[i].Snippet())
+	}
+	return strings.Join(out, "\n")
+}
+
+// validator walks the syntax tree, checking function names, argument counts and constant argument types.
+type validator struct {
+	file     string
+	source   string
+	problems []*Problem
+}
+
+func (v *validator) report(span Span, format string, args ...interface{}) {
+	v.problems = append(v.problems, newProblem(v.file, v.source, span, fmt.Sprintf(format, args...)))
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: v.problems}
+}
+
+func (v *validator) validate(eCtx ExpressionConstructorContext, expr Expression) {
+	switch expr := expr.(type) {
+	case *Variable:
+		if !eCtx.hasVariable(expr.Name) {
+			v.report(expr.Span, "undefined variable: $%s", expr.Name)
+		}
+	case *Symbol:
+		if _, ok := eCtx.UserFunctions[expr.Name]; !ok {
+			v.report(expr.Span, "unexpected name %s, functions are called like (%s ...)", expr.Name, expr.Name)
+		}
+	case *SExpression:
+		v.validateSExpression(eCtx, expr)
+	}
+}
+
+func (v *validator) validateSExpression(eCtx ExpressionConstructorContext, e *SExpression) {
+	switch e.Name {
+	case "":
+		v.report(e.Span, "empty expression")
+		return
+	case "let":
+		v.validateLet(eCtx, e)
+		return
+	case "fn":
+		if len(e.Args) != 2 {
+			v.report(e.Span, "fn should be of the form (fn (parameters...) body), got %d arguments", len(e.Args))
+			return
+		}
+		parameters, err := parameterNames(e.Args[0])
+		if err != nil {
+			v.report(e.Span, "invalid fn parameters: %s", err)
+			return
+		}
+		v.validate(eCtx.WithVariables(parameters...), e.Args[1])
+		return
+	case "defn", "import":
+		v.report(e.Span, "%s can only be used at the top level, before the query", e.Name)
+		return
+	}
+
+	for i := range e.Args {
+		v.validate(eCtx, e.Args[i])
+	}
+
+	if userFunction, ok := eCtx.UserFunctions[e.Name]; ok {
+		if len(e.Args) != len(userFunction.Parameters) {
+			v.report(e.Span, "function %s expects %d arguments, got %d", e.Name, len(userFunction.Parameters), len(e.Args))
+		}
+		return
+	}
+
+	f, ok := eCtx.Functions.Lookup(e.Name)
+	if !ok {
+		if suggestion := eCtx.suggestFunction(e.Name); suggestion != "" {
+			v.report(e.Span, "no such function: %s, did you mean %s?", e.Name, suggestion)
+		} else {
+			v.report(e.Span, "no such function: %s", e.Name)
+		}
+		return
+	}
+	if err := f.CheckArgCount(len(e.Args)); err != nil {
+		v.report(e.Span, "%s", err)
+	}
+	for i := range e.Args {
+		constant, ok := e.Args[i].(*Constant)
+		if !ok {
+			continue
+		}
+		if expected := f.ArgType(i); jql.TypeOf(constant.Value)&expected == 0 {
+			v.report(constant.Span, "argument with index %d to %s should be %s, is %v of type %s", i, e.Name, expected, constant.Value, jql.TypeOf(constant.Value))
+		}
+	}
+}
+
+func (v *validator) validateLet(eCtx ExpressionConstructorContext, e *SExpression) {
+	if len(e.Args) < 2 {
+		v.report(e.Span, "let needs at least one binding and a body, got %d arguments", len(e.Args))
+		return
+	}
+
+	bindings := e.Args[:len(e.Args)-1]
+	for i := range bindings {
+		binding, ok := bindings[i].(*SExpression)
+		if !ok || len(binding.Args) != 1 || binding.Name == "elem" {
+			v.report(e.Span, "let binding with index %d should be of the form (name value)", i)
+			continue
+		}
+		v.validate(eCtx, binding.Args[0])
+		eCtx = eCtx.WithVariables(binding.Name)
+	}
+
+	v.validate(eCtx, e.Args[len(e.Args)-1])
+}
+
+var specialForms = []string{"let", "fn", "defn", "import"}
+
+// suggestFunction returns the name of a known function closest to the given misspelled one, or an empty string if none is close enough.
+func (eCtx ExpressionConstructorContext) suggestFunction(name string) string {
+	candidates := append(eCtx.Functions.Names(), specialForms...)
+	for userFunction := range eCtx.UserFunctions {
+		candidates = append(candidates, userFunction)
+	}
+	sort.Strings(candidates)
+
+	maxDistance := 2
+	if len([]rune(name)) <= 3 {
+		maxDistance = 1
+	}
+
+	best := ""
+	bestDistance := maxDistance + 1
+	for _, candidate := range candidates {
+		if distance := editDistance(name, candidate); distance < bestDistance {
+			best = candidate
+			bestDistance = distance
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between the two strings, counted in runes.
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	previous := make([]int, len(br)+1)
+	current := make([]int, len(br)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		current[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(br)]
+}
+
+func minInt(values ...int) int {
+	out := values[0]
+	for _, value := range values[1:] {
+		if value < out {
+			out = value
+		}
+	}
+	return out
+}
@@ -0,0 +1,246 @@
+package query
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/cube2222/jql/jql"
+	"github.com/cube2222/jql/jql/functions"
+	"github.com/cube2222/jql/jql/parser"
+)
+
+type Options struct {
+	// Functions are the functions available to the query, functions.DefaultRegistry() if nil.
+	Functions *jql.FunctionRegistry
+	// LibPath are the directories imported libraries are looked up in. Imports are disabled if it's empty.
+	LibPath []string
+	// Variables are made available to the query, referenced as $name.
+	// Their values can be overridden for a single evaluation with EvalWithVariables.
+	Variables map[string]interface{}
+	// Limits restrict the resources each evaluation of the query may use. Going over one returns a *jql.LimitError.
+	Limits jql.Limits
+}
+
+// Query is a compiled query. It's safe for concurrent use.
+type Query struct {
+	expression jql.Expression
+	env        jql.Environment
+	limits     jql.Limits
+	variables  map[string]bool
+}
+
+// Compile parses the query and constructs its expression, so it can be evaluated many times.
+// Syntax errors are returned as a *parser.ParseError.
+func Compile(query string, opts Options) (*Query, error) {
+	parsed, err := parser.Parse(query)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse query: %w", err)
+	}
+
+	variableNames := make([]string, 0, len(opts.Variables))
+	for name := range opts.Variables {
+		variableNames = append(variableNames, name)
+	}
+	sort.Strings(variableNames)
+
+	var env jql.Environment
+	variables := make(map[string]bool, len(variableNames))
+	for _, name := range variableNames {
+		variables[name] = true
+		value, err := normalize(opts.Variables[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of variable $%s: %w", name, err)
+		}
+		env = env.WithVariable(name, value)
+	}
+
+	registry := opts.Functions
+	if registry == nil {
+		registry = functions.DefaultRegistry()
+	}
+
+	eCtx := parser.ExpressionConstructorContext{
+		Functions: registry,
+		ConstantExpression: func(value interface{}) jql.Expression {
+			return jql.NewConstant(value)
+		},
+		VariableExpression:     jql.NewVariable,
+		LetExpression:          jql.NewLet,
+		LambdaExpression:       jql.NewLambda,
+		FunctionCallExpression: jql.NewFunctionCall,
+		Variables:              variableNames,
+	}
+	if len(opts.LibPath) > 0 {
+		eCtx.LoadLibrary = parser.LibraryLoader(opts.LibPath)
+	}
+
+	expr, err := parsed.GetExecutionExpression(eCtx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get execution expression from AST: %w", err)
+	}
+
+	return &Query{
+		expression: expr,
+		env:        env,
+		limits:     opts.Limits,
+		variables:  variables,
+	}, nil
+}
+
+// Eval evaluates the query against the value, which can be anything encoding/json would decode,
+// as well as other Go maps with string keys, slices, arrays and numbers.
+// Structs and other values are converted by encoding them to JSON and decoding them back.
+// Evaluation is aborted with the context's error when the context is cancelled or its deadline passes.
+func (q *Query) Eval(ctx context.Context, value interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	value, err := normalize(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input value: %w", err)
+	}
+
+	return q.expression.Get(q.env.WithContext(ctx).WithLimits(q.limits), value)
+}
+
+// EvalWithVariables is like Eval, but overrides the values of the given variables for this evaluation.
+// All of them have to be declared in the options the query was compiled with.
+func (q *Query) EvalWithVariables(ctx context.Context, value interface{}, variables map[string]interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	value, err := normalize(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input value: %w", err)
+	}
+
+	env := q.env
+	for name, variableValue := range variables {
+		if !q.variables[name] {
+			return nil, fmt.Errorf("variable $%s wasn't declared when compiling the query", name)
+		}
+		variableValue, err := normalize(variableValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of variable $%s: %w", name, err)
+		}
+		env = env.WithVariable(name, variableValue)
+	}
+
+	return q.expression.Get(env.WithContext(ctx).WithLimits(q.limits), value)
+}
+
+// normalize converts the value into the form encoding/json decodes into, keeping numbers exact.
+func normalize(value interface{}) (interface{}, error) {
+	if isNormalized(value) {
+		return value, nil
+	}
+	return normalizeValue(reflect.ValueOf(value))
+}
+
+func isNormalized(value interface{}) bool {
+	switch typed := value.(type) {
+	case nil, bool, string, int, float64, json.Number:
+		return true
+	case []interface{}:
+		for i := range typed {
+			if !isNormalized(typed[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		for k := range typed {
+			if !isNormalized(typed[k]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func normalizeValue(value reflect.Value) (interface{}, error) {
+	switch value.Kind() {
+	case reflect.Invalid:
+		return nil, nil
+	case reflect.Interface, reflect.Ptr:
+		if value.IsNil() {
+			return nil, nil
+		}
+		if _, ok := value.Interface().(json.Marshaler); ok {
+			return normalizeThroughJSON(value.Interface())
+		}
+		return normalizeValue(value.Elem())
+	case reflect.Bool:
+		return value.Bool(), nil
+	case reflect.String:
+		if number, ok := value.Interface().(json.Number); ok {
+			return number, nil
+		}
+		return value.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return json.Number(strconv.FormatInt(value.Int(), 10)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return json.Number(strconv.FormatUint(value.Uint(), 10)), nil
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), nil
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			return nil, nil
+		}
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			// Byte slices are encoded as base64 strings by encoding/json.
+			return normalizeThroughJSON(value.Interface())
+		}
+		out := make([]interface{}, value.Len())
+		for i := range out {
+			var err error
+			out[i], err = normalizeValue(value.Index(i))
+			if err != nil {
+				return nil, fmt.Errorf("invalid value at index %d: %w", i, err)
+			}
+		}
+		return out, nil
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return normalizeThroughJSON(value.Interface())
+		}
+		if value.IsNil() {
+			return nil, nil
+		}
+		out := make(map[string]interface{}, value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			var err error
+			out[iter.Key().String()], err = normalizeValue(iter.Value())
+			if err != nil {
+				return nil, fmt.Errorf("invalid value at key %s: %w", iter.Key().String(), err)
+			}
+		}
+		return out, nil
+	default:
+		return normalizeThroughJSON(value.Interface())
+	}
+}
+
+func normalizeThroughJSON(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't encode value of type %s as JSON: %w", reflect.TypeOf(value), err)
+	}
+	var out interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&out); err != nil {
+		return nil, fmt.Errorf("couldn't decode value of type %s from JSON: %w", reflect.TypeOf(value), err)
+	}
+	return out, nil
+}
@@ -0,0 +1,408 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cube2222/jql/jql"
+	"github.com/cube2222/jql/jql/functions"
+	"github.com/cube2222/jql/jql/parser"
+)
+
+func TestQuery_Eval(t *testing.T) {
+	type item struct {
+		Name  string `json:"name"`
+		Price int    `json:"price"`
+	}
+
+	tests := []struct {
+		query     string
+		variables map[string]interface{}
+		input     interface{}
+		output    interface{}
+	}{
+		{
+			query:  `("countries" ((keys) ("name")))`,
+			input:  map[string]interface{}{"countries": []interface{}{map[string]interface{}{"name": "Poland"}}},
+			output: []interface{}{"Poland"},
+		},
+		{
+			query:  `((keys) (gt (id) 2))`,
+			input:  []int{1, 2, 3},
+			output: []interface{}{false, false, true},
+		},
+		{
+			query:  `("tags" (1))`,
+			input:  map[string][]string{"tags": {"a", "b"}},
+			output: "b",
+		},
+		{
+			query:  `(eq ("id") 12345678901234567891)`,
+			input:  map[string]uint64{"id": 12345678901234567891},
+			output: true,
+		},
+		{
+			query: `(filter (gt ("price") $min))`,
+			input: []item{{Name: "cheap", Price: 5}, {Name: "expensive", Price: 50}},
+			variables: map[string]interface{}{
+				"min": 10,
+			},
+			output: []interface{}{map[string]interface{}{"name": "expensive", "price": json.Number("50")}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := Compile(tt.query, Options{Variables: tt.variables})
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			out, err := q.Eval(context.Background(), tt.input)
+			if err != nil {
+				t.Fatalf("Eval() error = %v", err)
+			}
+			assert.Equal(t, tt.output, out)
+		})
+	}
+}
+
+func TestQuery_EvalConcurrent(t *testing.T) {
+	q, err := Compile(`(let (n ("n")) (array $n (call (fn (x) (sprintf "%v" $x)) $n)))`, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				out, err := q.Eval(context.Background(), map[string]int{"n": i})
+				if assert.NoError(t, err) {
+					assert.Equal(t, []interface{}{json.Number(strconv.Itoa(i)), strconv.Itoa(i)}, out)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestQuery_EvalWithVariables(t *testing.T) {
+	q, err := Compile(`(array $acc ("n"))`, Options{Variables: map[string]interface{}{"acc": nil}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := q.Eval(context.Background(), map[string]int{"n": 1})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []interface{}{nil, json.Number("1")}, out)
+	}
+	out, err = q.EvalWithVariables(context.Background(), map[string]int{"n": 2}, map[string]interface{}{"acc": out})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []interface{}{[]interface{}{nil, json.Number("1")}, json.Number("2")}, out)
+	}
+
+	_, err = q.EvalWithVariables(context.Background(), nil, map[string]interface{}{"other": 1})
+	assert.EqualError(t, err, "variable $other wasn't declared when compiling the query")
+}
+
+func TestQuery_EvalDoesntMutateInput(t *testing.T) {
+	q, err := Compile(`(array (set "a" 2) (delete "b") (rename "a" "c") (pick "a") (deepmerge (id) (object "o" (object "y" 2))) (setpath (array "o" "x") 2) (delpath (array "o" "x")) (walk (ifte (isnumber (id)) 3 (id))) (id))`, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := q.Eval(context.Background(), map[string]interface{}{"a": 1, "b": 1, "o": map[string]int{"x": 1}})
+	if assert.NoError(t, err) {
+		one := json.Number("1")
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"a": json.Number("2"), "b": one, "o": map[string]interface{}{"x": one}},
+			map[string]interface{}{"a": one, "o": map[string]interface{}{"x": one}},
+			map[string]interface{}{"b": one, "c": one, "o": map[string]interface{}{"x": one}},
+			map[string]interface{}{"a": one},
+			map[string]interface{}{"a": one, "b": one, "o": map[string]interface{}{"x": one, "y": json.Number("2")}},
+			map[string]interface{}{"a": one, "b": one, "o": map[string]interface{}{"x": json.Number("2")}},
+			map[string]interface{}{"a": one, "b": one, "o": map[string]interface{}{}},
+			map[string]interface{}{"a": json.Number("3"), "b": json.Number("3"), "o": map[string]interface{}{"x": json.Number("3")}},
+			map[string]interface{}{"a": one, "b": one, "o": map[string]interface{}{"x": one}},
+		}, out)
+	}
+}
+
+func TestQuery_EvalCancelled(t *testing.T) {
+	for _, query := range []string{
+		`(pipe (range 100000) ((keys) (pipe (range 100000) ((keys) (id)))))`,
+		`(recover (pipe (range 100000) ((keys) (pipe (range 100000) ((keys) (id))))))`,
+		`(range 0 16000000)`,
+	} {
+		t.Run(query, func(t *testing.T) {
+			q, err := Compile(query, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			_, err = q.Eval(ctx, nil)
+			assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q, err := Compile(`(id)`, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = q.Eval(ctx, nil)
+	assert.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+}
+
+func TestQuery_EvalLimits(t *testing.T) {
+	tests := []struct {
+		query  string
+		limits jql.Limits
+		limit  string
+	}{
+		{
+			query:  `(range 0 2000000000)`,
+			limits: jql.Limits{MaxElements: 1000},
+			limit:  "output elements",
+		},
+		{
+			query:  `(defn loop (x) (loop $x)) (loop 1)`,
+			limits: jql.Limits{MaxDepth: 100},
+			limit:  "recursion depth",
+		},
+		{
+			query: `(defn loop (x) (loop $x)) (loop 1)`,
+			limit: "recursion depth",
+		},
+		{
+			query:  `(sprintf "%s%s" "abc" "def")`,
+			limits: jql.Limits{MaxStringLength: 5},
+			limit:  "string length",
+		},
+		{
+			query:  `(sprintf "%1000000d%1000000d" 1 2)`,
+			limits: jql.Limits{MaxStringLength: 1500000},
+			limit:  "string length",
+		},
+		{
+			query: `(repeat "ab" 100000000)`,
+			limit: "string length",
+		},
+		{
+			query: `(padleft "a" 4000000000000000000)`,
+			limit: "string length",
+		},
+		{
+			query:  `(pipe (array 1 2 3) (map (array (id) (id) (id))))`,
+			limits: jql.Limits{MaxElements: 10},
+			limit:  "output elements",
+		},
+		{
+			query:  `(fromjson "[1, 2, 3, 4, 5, 6]")`,
+			limits: jql.Limits{MaxElements: 5},
+			limit:  "output elements",
+		},
+		{
+			query:  `(pipe (object "a" 1 "b" 2 "c" 3) (merge (id) (id) (object "d" 4)))`,
+			limits: jql.Limits{MaxElements: 6},
+			limit:  "output elements",
+		},
+		{
+			query:  `(join (array "abc" "def"))`,
+			limits: jql.Limits{MaxStringLength: 5},
+			limit:  "string length",
+		},
+		{
+			query:  `(pipe (range 1000) ((keys) (pipe (range 1000) ((keys) (id)))))`,
+			limits: jql.Limits{MaxSteps: 10000},
+			limit:  "evaluation steps",
+		},
+		{
+			query:  `(range -9223372036854775808 9223372036854775807)`,
+			limits: jql.Limits{MaxElements: 1000},
+			limit:  "output elements",
+		},
+		{
+			query: `(range -9223372036854775808 9223372036854775807)`,
+			limit: "output elements",
+		},
+		{
+			query: `(setpath (array 9223372036854775807) 1)`,
+			limit: "output elements",
+		},
+		{
+			query:  `(setpath (array 10000000000) 1)`,
+			limits: jql.Limits{MaxElements: 1000},
+			limit:  "output elements",
+		},
+		{
+			query:  `(pipe (range 1000) (map (range 1000)))`,
+			limits: jql.Limits{MaxSteps: 3000},
+			limit:  "evaluation steps",
+		},
+		{
+			query:  `(pipe (range 1000) (map (range 1000)))`,
+			limits: jql.Limits{MaxElements: 1000},
+			limit:  "output elements",
+		},
+		{
+			query:  `(recover (range 0 2000000000))`,
+			limits: jql.Limits{MaxElements: 1000},
+			limit:  "output elements",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := Compile(tt.query, Options{Limits: tt.limits})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = q.Eval(context.Background(), nil)
+			var limitErr *jql.LimitError
+			if assert.True(t, errors.As(err, &limitErr), "unexpected error: %v", err) {
+				assert.Equal(t, tt.limit, limitErr.Limit)
+			}
+		})
+	}
+
+	q, err := Compile(`(join (array "abc" "de"))`, Options{Limits: jql.Limits{MaxStringLength: 5, MaxSteps: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		out, err := q.Eval(context.Background(), nil)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "abcde", out)
+		}
+	}
+}
+
+func TestQuery_EvalRecursionErrors(t *testing.T) {
+	q, err := Compile(`(defn loop (x) (ifte (gt $x 0) (loop (sub $x 1)) (error "done"))) (loop ("n"))`, Options{Limits: jql.Limits{MaxDepth: 5000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = q.Eval(context.Background(), map[string]int{"n": 10000})
+	assert.EqualError(t, err, "limit exceeded: recursion depth (max 5000)")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = q.Eval(ctx, map[string]int{"n": 10000})
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = q.Eval(context.Background(), map[string]int{"n": 3000})
+	if assert.Error(t, err) {
+		assert.Equal(t, 1, strings.Count(err.Error(), "error in function loop"), "unexpected error: %v", err)
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	_, err := Compile(`(elem "a"`, Options{})
+	assert.EqualError(t, err, `couldn't parse query: syntax error at line 1, column 10: unexpected end of query, expecting ')'`)
+
+	_, err = Compile(`(import "lib.jql") (id)`, Options{})
+	assert.EqualError(t, err, `couldn't get execution expression from AST: couldn't import library with index 0: importing libraries isn't supported`)
+
+	_, err = Compile(`$undefined`, Options{})
+	assert.EqualError(t, err, `couldn't get execution expression from AST: line 1, column 1: undefined variable: $undefined`)
+
+	_, err = Compile(`(zip)`, Options{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "zip expects at least 1 arguments, got 0")
+	}
+
+	_, err = Compile(`(test "(" "a")`, Options{})
+	assert.EqualError(t, err, "couldn't get execution expression from AST: couldn't get expression for function test: invalid test pattern: error parsing regexp: missing closing ): `(`")
+
+	_, err = Compile("(defn f (x) (filtr $x))\n(pipe (f) (ifte 1 2) (range \"a\"))", Options{})
+	var validationErr *parser.ValidationError
+	if assert.True(t, errors.As(err, &validationErr), "unexpected error: %v", err) {
+		var problems []string
+		for _, problem := range validationErr.Problems {
+			problems = append(problems, problem.Error())
+		}
+		assert.Equal(t, []string{
+			"line 1, column 13: no such function: filtr, did you mean filter?",
+			"line 2, column 7: function f expects 1 arguments, got 0",
+			"line 2, column 11: ifte expects 3 arguments, got 2",
+			"line 2, column 29: argument with index 0 to range should be number, is a of type string",
+		}, problems)
+		assert.Equal(t, parser.Span{Start: 52, End: 55}, validationErr.Problems[3].Span)
+	}
+}
+
+type double struct {
+	Value jql.Expression
+}
+
+func (t double) Get(env jql.Environment, arg interface{}) (interface{}, error) {
+	value, err := t.Value.Get(env, arg)
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Sprintf("%v%v", value, value), nil
+}
+
+func TestCompile_Functions(t *testing.T) {
+	registry := functions.DefaultRegistry()
+	err := registry.Register(&jql.Function{
+		Name: "double",
+		Constructor: func(ts ...jql.Expression) (jql.Expression, error) {
+			return double{Value: ts[0]}, nil
+		},
+		MinArgs:    1,
+		MaxArgs:    1,
+		ReturnType: jql.TypeString,
+		Doc:        "Stringifies its argument twice.",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.EqualError(t, registry.Register(&jql.Function{Name: "double", Constructor: newNull}), "function double is already registered")
+	assert.EqualError(t, registry.Replace(&jql.Function{Name: "double"}), "function double has no constructor")
+
+	q, err := Compile(`(double ("name"))`, Options{Functions: registry})
+	if assert.NoError(t, err) {
+		out, err := q.Eval(context.Background(), map[string]interface{}{"name": "ab"})
+		assert.NoError(t, err)
+		assert.Equal(t, "abab", out)
+	}
+
+	_, err = Compile(`(double)`, Options{Functions: registry})
+	assert.EqualError(t, err, "couldn't get execution expression from AST: line 1, column 1: double expects 1 arguments, got 0")
+
+	_, err = Compile(`(double "a")`, Options{})
+	assert.EqualError(t, err, "couldn't get execution expression from AST: line 1, column 1: no such function: double")
+
+	restricted, err := registry.Restrict("elem", "double")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"double", "elem"}, restricted.Names())
+		_, err = Compile(`("a" (keys))`, Options{Functions: restricted})
+		assert.EqualError(t, err, "couldn't get execution expression from AST: line 1, column 6: no such function: keys")
+	}
+
+	_, ok := registry.Without("keys").Lookup("keys")
+	assert.False(t, ok)
+	_, ok = registry.Lookup("keys")
+	assert.True(t, ok)
+}
+
+func newNull(ts ...jql.Expression) (jql.Expression, error) {
+	return jql.NewConstant(nil), nil
+}
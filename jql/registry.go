@@ -0,0 +1,216 @@
+package jql
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Type is a set of JSON value types, used to describe function arguments and return values.
+type Type uint8
+
+const (
+	TypeNull Type = 1 << iota
+	TypeBool
+	TypeNumber
+	TypeString
+	TypeArray
+	TypeObject
+	TypeFunction
+
+	TypeAny = TypeNull | TypeBool | TypeNumber | TypeString | TypeArray | TypeObject | TypeFunction
+)
+
+var typeNames = []struct {
+	t    Type
+	name string
+}{
+	{TypeNull, "null"},
+	{TypeBool, "bool"},
+	{TypeNumber, "number"},
+	{TypeString, "string"},
+	{TypeArray, "array"},
+	{TypeObject, "object"},
+	{TypeFunction, "function"},
+}
+
+func (t Type) String() string {
+	if t == TypeAny {
+		return "any"
+	}
+	var names []string
+	for _, typeName := range typeNames {
+		if t&typeName.t != 0 {
+			names = append(names, typeName.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// TypeOf returns the type of a value, or 0 if it's not a value jql works with.
+func TypeOf(value interface{}) Type {
+	switch value.(type) {
+	case nil:
+		return TypeNull
+	case bool:
+		return TypeBool
+	case int, float64, json.Number:
+		return TypeNumber
+	case string:
+		return TypeString
+	case []interface{}:
+		return TypeArray
+	case map[string]interface{}:
+		return TypeObject
+	case *Closure:
+		return TypeFunction
+	default:
+		return 0
+	}
+}
+
+// Function describes a function available in queries.
+type Function struct {
+	Name        string
+	Constructor func(...Expression) (Expression, error)
+	// MinArgs and MaxArgs bound the argument count, MaxArgs is -1 for variadic functions.
+	MinArgs int
+	MaxArgs int
+	// ArgTypes are the types the arguments should evaluate to. The last one applies to any remaining arguments.
+	ArgTypes   []Type
+	ReturnType Type
+	Doc        string
+}
+
+// ArgType returns the type the argument with the given index should evaluate to.
+func (f *Function) ArgType(i int) Type {
+	if len(f.ArgTypes) == 0 {
+		return TypeAny
+	}
+	if i >= len(f.ArgTypes) {
+		return f.ArgTypes[len(f.ArgTypes)-1]
+	}
+	return f.ArgTypes[i]
+}
+
+// CheckArgCount returns an error if the function can't be called with the given argument count.
+func (f *Function) CheckArgCount(count int) error {
+	switch {
+	case f.MinArgs == f.MaxArgs && count != f.MinArgs:
+		return fmt.Errorf("%s expects %d arguments, got %d", f.Name, f.MinArgs, count)
+	case count < f.MinArgs:
+		return fmt.Errorf("%s expects at least %d arguments, got %d", f.Name, f.MinArgs, count)
+	case f.MaxArgs != -1 && count > f.MaxArgs:
+		return fmt.Errorf("%s expects at most %d arguments, got %d", f.Name, f.MaxArgs, count)
+	}
+	return nil
+}
+
+// New checks the argument count and constructs the expression.
+func (f *Function) New(args ...Expression) (Expression, error) {
+	if err := f.CheckArgCount(len(args)); err != nil {
+		return nil, err
+	}
+	return f.Constructor(args...)
+}
+
+// FunctionRegistry holds the functions available to a query.
+// It's not safe for concurrent modification, but it's safe to compile queries with it concurrently.
+type FunctionRegistry struct {
+	functions map[string]*Function
+}
+
+func NewFunctionRegistry(functions ...*Function) (*FunctionRegistry, error) {
+	registry := &FunctionRegistry{
+		functions: make(map[string]*Function, len(functions)),
+	}
+	for _, function := range functions {
+		if err := registry.Register(function); err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// Register adds the function to the registry, failing if a function with that name already exists.
+func (r *FunctionRegistry) Register(function *Function) error {
+	if err := validateFunction(function); err != nil {
+		return err
+	}
+	if _, ok := r.functions[function.Name]; ok {
+		return fmt.Errorf("function %s is already registered", function.Name)
+	}
+	r.functions[function.Name] = function
+	return nil
+}
+
+// Replace adds the function to the registry, replacing any function with the same name.
+// If the new function is invalid, the registry is left as it was.
+func (r *FunctionRegistry) Replace(function *Function) error {
+	if err := validateFunction(function); err != nil {
+		return err
+	}
+	r.functions[function.Name] = function
+	return nil
+}
+
+func validateFunction(function *Function) error {
+	if function.Name == "" || strings.Contains(function.Name, "/") {
+		return fmt.Errorf("invalid function name: %q", function.Name)
+	}
+	if function.Constructor == nil {
+		return fmt.Errorf("function %s has no constructor", function.Name)
+	}
+	return nil
+}
+
+func (r *FunctionRegistry) Lookup(name string) (*Function, bool) {
+	function, ok := r.functions[name]
+	return function, ok
+}
+
+// Names returns the sorted names of all the functions in the registry.
+func (r *FunctionRegistry) Names() []string {
+	names := make([]string, 0, len(r.functions))
+	for name := range r.functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Clone returns a copy of the registry, which can be extended without affecting the original.
+func (r *FunctionRegistry) Clone() *FunctionRegistry {
+	out := &FunctionRegistry{
+		functions: make(map[string]*Function, len(r.functions)),
+	}
+	for name, function := range r.functions {
+		out.functions[name] = function
+	}
+	return out
+}
+
+// Restrict returns a copy of the registry containing only the functions with the given names.
+func (r *FunctionRegistry) Restrict(names ...string) (*FunctionRegistry, error) {
+	out := &FunctionRegistry{
+		functions: make(map[string]*Function, len(names)),
+	}
+	for _, name := range names {
+		function, ok := r.functions[name]
+		if !ok {
+			return nil, fmt.Errorf("no such function: %s", name)
+		}
+		out.functions[name] = function
+	}
+	return out, nil
+}
+
+// Without returns a copy of the registry without the functions with the given names.
+func (r *FunctionRegistry) Without(names ...string) *FunctionRegistry {
+	out := r.Clone()
+	for _, name := range names {
+		delete(out.functions, name)
+	}
+	return out
+}